@@ -0,0 +1,254 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/models"
+	"github.com/NethServer/ns-api-server/utils"
+)
+
+type APIKey struct {
+	ID         string             `json:"id" structs:"id"`
+	Name       string             `json:"name" structs:"name"`
+	Hash       string             `json:"hash,omitempty" structs:"hash,omitempty"`
+	Scopes     []models.UBusScope `json:"scopes" structs:"scopes"`
+	Networks   []string           `json:"networks" structs:"networks"`
+	Expire     *time.Time         `json:"expire,omitempty" structs:"expire,omitempty"`
+	Created    time.Time          `json:"created" structs:"created"`
+	CreatedBy  string             `json:"created_by" structs:"created_by"`
+	LastUsed   *time.Time         `json:"last_used,omitempty" structs:"last_used,omitempty"`
+	UsageCount int                `json:"usage_count" structs:"usage_count"`
+}
+
+var keys []APIKey
+var mutex sync.Mutex
+var lastSave time.Time
+var unsaved bool
+
+// usage counters are written to disk at most once in this interval
+var saveInterval = time.Minute
+
+func keysFile() string {
+	return configuration.Get().KeysDir + "/api_keys.json"
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func Init() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// read api keys from disk
+	keysB, err := ioutil.ReadFile(keysFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "api keys file read error")
+	}
+	if errJson := json.Unmarshal(keysB, &keys); errJson != nil {
+		return errors.Wrap(errJson, "api keys file malformed")
+	}
+
+	return nil
+}
+
+func save() error {
+	// check if dir exists, otherwise create it
+	if _, errD := os.Stat(configuration.Get().KeysDir); os.IsNotExist(errD) {
+		_ = os.MkdirAll(configuration.Get().KeysDir, 0700)
+	}
+
+	// convert api keys to json
+	keysB, _ := json.MarshalIndent(keys, "", "  ")
+
+	// write to temp file and move it, to avoid partial files
+	tmpFile := keysFile() + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, keysB, 0600); err != nil {
+		return errors.Wrap(err, "api keys file write error")
+	}
+	lastSave = time.Now()
+	unsaved = false
+
+	return os.Rename(tmpFile, keysFile())
+}
+
+func Flush() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// write only pending usage counters
+	if !unsaved {
+		return nil
+	}
+
+	return save()
+}
+
+func List() []APIKey {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// return api keys without hashes
+	list := []APIKey{}
+	for _, key := range keys {
+		key.Hash = ""
+		list = append(list, key)
+	}
+
+	return list
+}
+
+func Create(request models.APIKeyJSON, username string) (APIKey, string, error) {
+	// validate networks
+	for _, network := range request.Networks {
+		if _, _, err := net.ParseCIDR(network); err != nil && net.ParseIP(network) == nil {
+			return APIKey{}, "", errors.New("invalid network " + network)
+		}
+	}
+
+	// validate scopes
+	for _, scope := range request.Scopes {
+		if _, err := path.Match(scope.Path, ""); err != nil || scope.Path == "" {
+			return APIKey{}, "", errors.New("invalid scope path " + scope.Path)
+		}
+		if _, err := path.Match(scope.Method, ""); err != nil || scope.Method == "" {
+			return APIKey{}, "", errors.New("invalid scope method " + scope.Method)
+		}
+	}
+
+	// generate random id and secret
+	random := make([]byte, 36)
+	if _, err := rand.Read(random); err != nil {
+		return APIKey{}, "", errors.Wrap(err, "api key generation error")
+	}
+	id := hex.EncodeToString(random[:4])
+	secret := id + "." + base64.RawURLEncoding.EncodeToString(random[4:])
+
+	// create api key
+	key := APIKey{
+		ID:        id,
+		Name:      request.Name,
+		Hash:      hash(secret),
+		Scopes:    request.Scopes,
+		Networks:  request.Networks,
+		Expire:    request.Expire,
+		Created:   time.Now(),
+		CreatedBy: username,
+	}
+	if key.Scopes == nil {
+		key.Scopes = []models.UBusScope{}
+	}
+	if key.Networks == nil {
+		key.Networks = []string{}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// save api keys
+	keys = append(keys, key)
+	if err := save(); err != nil {
+		keys = keys[:len(keys)-1]
+		return APIKey{}, "", err
+	}
+
+	key.Hash = ""
+	return key, secret, nil
+}
+
+func Delete(id string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// remove api key
+	for i, key := range keys {
+		if key.ID == id {
+			keys = append(keys[:i], keys[i+1:]...)
+			return save() == nil
+		}
+	}
+
+	return false
+}
+
+func Validate(secret string, clientIP string) (APIKey, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// search key by id
+	id := strings.SplitN(secret, ".", 2)[0]
+	for i, key := range keys {
+		if key.ID != id {
+			continue
+		}
+
+		// compare hash
+		if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash(secret))) != 1 {
+			return APIKey{}, errors.New("api key invalid")
+		}
+
+		// check expiration
+		now := time.Now()
+		if key.Expire != nil && key.Expire.Before(now) {
+			return APIKey{}, errors.New("api key expired")
+		}
+
+		// check source address
+		if !utils.AllowedIP(key.Networks, clientIP) {
+			return APIKey{}, errors.New("api key not allowed from " + clientIP)
+		}
+
+		// record usage
+		keys[i].LastUsed = &now
+		keys[i].UsageCount++
+		unsaved = true
+		if now.Sub(lastSave) > saveInterval {
+			_ = save()
+		}
+
+		return keys[i], nil
+	}
+
+	return APIKey{}, errors.New("api key invalid")
+}
+
+func (key APIKey) Allowed(ubusPath string, ubusMethod string) bool {
+	// check ubus object and method against scopes
+	for _, scope := range key.Scopes {
+		pathMatch, _ := path.Match(scope.Path, ubusPath)
+		methodMatch, _ := path.Match(scope.Method, ubusMethod)
+		if pathMatch && methodMatch {
+			return true
+		}
+	}
+
+	return false
+}
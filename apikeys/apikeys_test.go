@@ -0,0 +1,209 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package apikeys
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/models"
+)
+
+func setup(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	configuration.Set(configuration.Configuration{KeysDir: dir})
+	keys = nil
+	t.Cleanup(func() {
+		configuration.Set(configuration.Configuration{})
+		keys = nil
+	})
+	return dir
+}
+
+func create(t *testing.T, request models.APIKeyJSON) (APIKey, string) {
+	t.Helper()
+	key, secret, err := Create(request, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, secret
+}
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name    string
+		request models.APIKeyJSON
+		valid   bool
+	}{
+		{
+			name:    "scopes and networks",
+			request: models.APIKeyJSON{Name: "backup", Scopes: []models.UBusScope{{Path: "network.*", Method: "status"}}, Networks: []string{"10.0.0.0/8", "192.168.1.1"}},
+			valid:   true,
+		},
+		{
+			name:    "no networks",
+			request: models.APIKeyJSON{Name: "monitor", Scopes: []models.UBusScope{{Path: "system", Method: "*"}}},
+			valid:   true,
+		},
+		{
+			name:    "invalid network",
+			request: models.APIKeyJSON{Name: "bad", Scopes: []models.UBusScope{{Path: "system", Method: "*"}}, Networks: []string{"10.0.0.0/33"}},
+		},
+		{
+			name:    "invalid scope pattern",
+			request: models.APIKeyJSON{Name: "bad", Scopes: []models.UBusScope{{Path: "system[", Method: "*"}}},
+		},
+		{
+			name:    "empty scope method",
+			request: models.APIKeyJSON{Name: "bad", Scopes: []models.UBusScope{{Path: "system", Method: ""}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setup(t)
+
+			key, secret, err := Create(test.request, "admin")
+			if (err == nil) != test.valid {
+				t.Fatalf("created is %v, expected %v: %v", err == nil, test.valid, err)
+			}
+			if !test.valid {
+				return
+			}
+
+			// secret is returned once, only its hash is stored
+			if !strings.HasPrefix(secret, key.ID+".") || key.Hash != "" {
+				t.Errorf("unexpected key %+v with secret %v", key, secret)
+			}
+			stored, _ := ioutil.ReadFile(keysFile())
+			if strings.Contains(string(stored), secret) || !strings.Contains(string(stored), hash(secret)) {
+				t.Errorf("secret stored in clear: %s", stored)
+			}
+			for _, listed := range List() {
+				if listed.Hash != "" {
+					t.Errorf("hash listed for key %v", listed.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	setup(t)
+	past := time.Now().Add(-time.Hour)
+	limited, limitedSecret := create(t, models.APIKeyJSON{Name: "limited", Networks: []string{"10.0.0.0/8"}})
+	_, expiredSecret := create(t, models.APIKeyJSON{Name: "expired", Expire: &past})
+
+	tests := []struct {
+		name     string
+		secret   string
+		clientIP string
+		valid    bool
+	}{
+		{name: "allowed network", secret: limitedSecret, clientIP: "10.1.2.3", valid: true},
+		{name: "other network", secret: limitedSecret, clientIP: "192.168.1.1"},
+		{name: "invalid address", secret: limitedSecret, clientIP: "unix"},
+		{name: "wrong secret with known id", secret: limited.ID + ".guess", clientIP: "10.1.2.3"},
+		{name: "unknown id", secret: "00000000.guess", clientIP: "10.1.2.3"},
+		{name: "empty", secret: "", clientIP: "10.1.2.3"},
+		{name: "expired", secret: expiredSecret, clientIP: "10.1.2.3"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := Validate(test.secret, test.clientIP)
+			if (err == nil) != test.valid {
+				t.Fatalf("valid is %v, expected %v: %v", err == nil, test.valid, err)
+			}
+			if test.valid && key.ID != limited.ID {
+				t.Errorf("validated key is %v, expected %v", key.ID, limited.ID)
+			}
+		})
+	}
+
+	// usage is recorded
+	for _, key := range List() {
+		if key.ID == limited.ID && (key.UsageCount != 1 || key.LastUsed == nil) {
+			t.Errorf("usage not recorded: %+v", key)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	key := APIKey{Scopes: []models.UBusScope{
+		{Path: "network.interface.*", Method: "status"},
+		{Path: "system", Method: "*"},
+	}}
+
+	tests := []struct {
+		path    string
+		method  string
+		allowed bool
+	}{
+		{path: "network.interface.wan", method: "status", allowed: true},
+		{path: "network.interface.wan", method: "down"},
+		{path: "network.interface", method: "status"},
+		{path: "system", method: "reboot", allowed: true},
+		{path: "system.other", method: "info"},
+		{path: "file", method: "exec"},
+	}
+
+	for _, test := range tests {
+		if allowed := key.Allowed(test.path, test.method); allowed != test.allowed {
+			t.Errorf("%v %v allowed is %v, expected %v", test.path, test.method, allowed, test.allowed)
+		}
+	}
+
+	// no scopes, nothing allowed
+	if (APIKey{}).Allowed("system", "info") {
+		t.Error("key without scopes allowed")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	dir := setup(t)
+	key, secret := create(t, models.APIKeyJSON{Name: "backup"})
+	if _, err := Validate(secret, "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// keys and usage counters survive restart
+	keys = nil
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	if list := List(); len(list) != 1 || list[0].ID != key.ID || list[0].UsageCount != 1 {
+		t.Fatalf("unexpected keys after restart: %+v", list)
+	}
+
+	// deleted keys are not valid anymore
+	if !Delete(key.ID) || Delete(key.ID) {
+		t.Error("unexpected delete result")
+	}
+	if _, err := Validate(secret, "127.0.0.1"); err == nil {
+		t.Error("deleted key still valid")
+	}
+
+	// malformed file is reported
+	if err := ioutil.WriteFile(filepath.Join(dir, "api_keys.json"), []byte("["), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Init(); err == nil {
+		t.Error("malformed file accepted")
+	}
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/redact"
+)
+
+type Entry struct {
+	Seq     int64           `json:"seq"`
+	Time    time.Time       `json:"time"`
+	User    string          `json:"user"`
+	Role    string          `json:"role,omitempty"`
+	Auth    string          `json:"auth"`
+	IP      string          `json:"ip"`
+	Request string          `json:"request_id,omitempty"`
+	Action  string          `json:"action"`
+	Object  string          `json:"object,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Status  int             `json:"status"`
+	Result  string          `json:"result"`
+	Prev    string          `json:"prev"`
+	Hash    string          `json:"hash,omitempty"`
+}
+
+var file *os.File
+var lastSeq int64
+var lastHash string
+var mutex sync.Mutex
+
+func hash(entry Entry) string {
+	// hash covers all fields, including previous hash
+	entry.Hash = ""
+	entryB, _ := json.Marshal(entry)
+	sum := sha256.Sum256(entryB)
+	return hex.EncodeToString(sum[:])
+}
+
+func Init() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// check if dir exists, otherwise create it
+	path := configuration.Get().AuditFile
+	if _, errD := os.Stat(filepath.Dir(path)); os.IsNotExist(errD) {
+		_ = os.MkdirAll(filepath.Dir(path), 0700)
+	}
+
+	// continue chain from last entry
+	last, err := lastEntry(path)
+	if err != nil {
+		return err
+	}
+	lastSeq = last.Seq
+	lastHash = last.Hash
+
+	// entries are only appended
+	file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrap(err, "audit file open error")
+	}
+
+	// forward entries to remote syslog
+	if configuration.Get().AuditRemote != "" {
+		return startForwarder()
+	}
+
+	return nil
+}
+
+func lastEntry(path string) (Entry, error) {
+	var last Entry
+
+	auditFile, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return last, nil
+	} else if err != nil {
+		return last, errors.Wrap(err, "audit file read error")
+	}
+	defer auditFile.Close()
+
+	scanner := bufio.NewScanner(auditFile)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			return last, errors.Wrap(err, "audit file malformed")
+		}
+	}
+
+	return last, scanner.Err()
+}
+
+func Record(entry Entry) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if file == nil {
+		return
+	}
+
+	// payload is redacted again, whoever recorded it
+	if len(entry.Payload) > 0 {
+		entry.Payload = redact.JSON(entry.Payload)
+	}
+
+	// link entry to previous one
+	lastSeq++
+	entry.Seq = lastSeq
+	entry.Time = time.Now().UTC()
+	entry.Prev = lastHash
+	entry.Hash = hash(entry)
+	lastHash = entry.Hash
+
+	// write entry, audit failures are reported in logs
+	entryB, _ := json.Marshal(entry)
+	if _, err := file.Write(append(entryB, '\n')); err != nil {
+		logs.For("AUDIT").With(logs.Fields{"error": err.Error(), "seq": entry.Seq}).Crit("audit write error")
+	}
+
+	forward(entry, entryB)
+}
+
+func Close() {
+	// send pending entries to remote syslog
+	stopForwarder()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if file != nil {
+		file.Sync()
+		file.Close()
+		file = nil
+	}
+}
+
+func Verify(path string) (int64, error) {
+	auditFile, err := os.Open(path)
+	if err != nil {
+		return 0, errors.Wrap(err, "audit file read error")
+	}
+	defer auditFile.Close()
+
+	// check each entry hash and link to previous entry
+	var count int64
+	var prev Entry
+	scanner := bufio.NewScanner(auditFile)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return count, fmt.Errorf("line %d: entry malformed: %v", line, err)
+		}
+		if entry.Hash != hash(entry) {
+			return count, fmt.Errorf("line %d: entry %d was modified", line, entry.Seq)
+		}
+		if count > 0 && (entry.Prev != prev.Hash || entry.Seq != prev.Seq+1) {
+			return count, fmt.Errorf("line %d: entry %d does not follow entry %d, entries were removed or reordered", line, entry.Seq, prev.Seq)
+		}
+		if count == 0 && entry.Seq != 1 {
+			return count, fmt.Errorf("line %d: chain starts at entry %d, previous entries were removed", line, entry.Seq)
+		}
+		prev = entry
+		count++
+	}
+
+	return count, scanner.Err()
+}
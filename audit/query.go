@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/models"
+)
+
+func (entry Entry) Matches(query models.AuditQuery) bool {
+	switch {
+	case query.User != "" && entry.User != query.User:
+		return false
+	case query.From != nil && entry.Time.Before(*query.From):
+		return false
+	case query.To != nil && entry.Time.After(*query.To):
+		return false
+	case query.Action != "" && entry.Action != query.Action:
+		return false
+	case query.Object != "" && entry.Object != query.Object:
+		return false
+	case query.Method != "" && entry.Method != query.Method:
+		return false
+	case query.Result != "" && entry.Result != query.Result:
+		return false
+	case query.IP != "" && entry.IP != query.IP:
+		return false
+	case query.Request != "" && entry.Request != query.Request:
+		return false
+	}
+	return true
+}
+
+func Query(query models.AuditQuery) ([]Entry, int64, error) {
+	auditFile, err := os.Open(configuration.Get().AuditFile)
+	if os.IsNotExist(err) {
+		return []Entry{}, 0, nil
+	} else if err != nil {
+		return nil, 0, errors.Wrap(err, "audit file read error")
+	}
+	defer auditFile.Close()
+
+	// keep newest matching entries older than cursor, one more to detect next page
+	var window []Entry
+	scanner := bufio.NewScanner(auditFile)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// last line can be partially written
+			continue
+		}
+		if query.Cursor > 0 && entry.Seq >= query.Cursor {
+			break
+		}
+		if !entry.Matches(query) {
+			continue
+		}
+		window = append(window, entry)
+		if query.Limit > 0 && len(window) > query.Limit+1 {
+			window = window[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, errors.Wrap(err, "audit file read error")
+	}
+
+	// next page starts before the oldest returned entry
+	var next int64
+	if query.Limit > 0 && len(window) > query.Limit {
+		window = window[1:]
+		next = window[0].Seq
+	}
+
+	// newest entries first
+	entries := make([]Entry, 0, len(window))
+	for i := len(window) - 1; i >= 0; i-- {
+		entries = append(entries, window[i])
+	}
+
+	return entries, next, nil
+}
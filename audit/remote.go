@@ -0,0 +1,142 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package audit
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/logs"
+)
+
+// pending messages, entries are dropped when remote is too slow
+var queue chan []byte
+var done chan bool
+
+// time allowed to send pending messages on shutdown
+var flushTimeout = 5 * time.Second
+
+func startForwarder() error {
+	remote, err := url.Parse(configuration.Get().AuditRemote)
+	if err != nil || (remote.Scheme != "tcp" && remote.Scheme != "tls") || remote.Host == "" {
+		return errors.New("audit remote must be tcp://<host>:<port> or tls://<host>:<port>")
+	}
+
+	// verify remote with system or given ca
+	var tlsConfig *tls.Config
+	if remote.Scheme == "tls" {
+		tlsConfig = &tls.Config{ServerName: remote.Hostname(), MinVersion: tls.VersionTLS12}
+		if configuration.Get().AuditRemoteCAFile != "" {
+			caB, err := ioutil.ReadFile(configuration.Get().AuditRemoteCAFile)
+			if err != nil {
+				return errors.Wrap(err, "audit remote ca file read error")
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(caB) {
+				return errors.New("audit remote ca file has no valid certificates")
+			}
+		}
+	}
+
+	queue = make(chan []byte, 1000)
+	done = make(chan bool)
+	go send(remote.Host, tlsConfig)
+
+	return nil
+}
+
+func send(address string, tlsConfig *tls.Config) {
+	defer close(done)
+
+	var conn net.Conn
+	for message := range queue {
+		// retry until sent, reconnecting on errors
+		for attempt := 0; ; attempt++ {
+			if conn == nil {
+				var err error
+				dialer := &net.Dialer{Timeout: 10 * time.Second}
+				if tlsConfig != nil {
+					conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+				} else {
+					conn, err = dialer.Dial("tcp", address)
+				}
+				if err != nil {
+					conn = nil
+					if attempt == 0 {
+						logs.For("AUDIT").With(logs.Fields{"error": err.Error(), "remote": address}).Err("audit remote connection error")
+					}
+					time.Sleep(time.Duration(attempt+1) * time.Second)
+					continue
+				}
+			}
+
+			// octet counting framing, as in RFC 6587
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if _, err := conn.Write(append([]byte(strconv.Itoa(len(message))+" "), message...)); err != nil {
+				conn.Close()
+				conn = nil
+				continue
+			}
+			break
+		}
+	}
+
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+func forward(entry Entry, entryB []byte) {
+	if queue == nil {
+		return
+	}
+
+	// RFC 5424 message, facility authpriv and severity notice,
+	// timestamp has at most microseconds
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	message := fmt.Sprintf("<%d>1 %s %s ns-api-server %d audit - %s", 10*8+5, entry.Time.Format("2006-01-02T15:04:05.000000Z07:00"), hostname, os.Getpid(), entryB)
+
+	select {
+	case queue <- []byte(message):
+	default:
+		logs.For("AUDIT").With(logs.Fields{"seq": entry.Seq}).Err("audit remote queue full, entry not forwarded")
+	}
+}
+
+func stopForwarder() {
+	if queue == nil {
+		return
+	}
+
+	// wait for pending messages
+	mutex.Lock()
+	close(queue)
+	queue = nil
+	mutex.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(flushTimeout):
+		logs.For("AUDIT").Err("audit remote not reachable, pending entries not forwarded")
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package authenticator
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/logs"
+)
+
+type Authenticator interface {
+	Name() string
+	Authenticate(username string, password string) error
+}
+
+var chain []Authenticator
+
+func Init() error {
+	// build chain in configured order
+	chain = nil
+	for _, name := range configuration.Get().AuthProviders {
+		switch strings.TrimSpace(name) {
+		case "ubus":
+			chain = append(chain, &Ubus{})
+		case "htpasswd":
+			chain = append(chain, &Htpasswd{File: configuration.Get().HtpasswdFile})
+		case "ldap":
+			chain = append(chain, &LDAP{
+				URL:            configuration.Get().LDAPURL,
+				BindDN:         configuration.Get().LDAPBindDN,
+				BaseDN:         configuration.Get().LDAPBaseDN,
+				UserFilter:     configuration.Get().LDAPUserFilter,
+				SearchDN:       configuration.Get().LDAPSearchDN,
+				SearchPassword: configuration.Get().LDAPSearchPassword,
+				StartTLS:       configuration.Get().LDAPStartTLS,
+				CAFile:         configuration.Get().LDAPCAFile,
+			})
+		default:
+			return errors.New("unknown authentication provider " + name)
+		}
+	}
+
+	if len(chain) == 0 {
+		return errors.New("no authentication provider configured")
+	}
+
+	return nil
+}
+
+func Authenticate(username string, password string) (string, error) {
+	// try providers in order, first success wins
+	var failures []string
+	for _, provider := range chain {
+		err := provider.Authenticate(username, password)
+		if err != nil {
+			failures = append(failures, provider.Name()+": "+err.Error())
+			continue
+		}
+
+		// system users are admin, users of other providers need a mapped role
+		role := Role(username)
+		if role == "" && provider.Name() != "ubus" {
+			return "", errors.New(provider.Name() + ": user " + username + " has no mapped role")
+		}
+		if role == "" {
+			role = "admin"
+		}
+
+		logs.For("AUTH").With(logs.Fields{"user": username, "provider": provider.Name(), "role": role}).Info("user authenticated")
+		return role, nil
+	}
+
+	return "", errors.New(strings.Join(failures, ", "))
+}
+
+func Role(username string) string {
+	// role mapped in configuration, if any
+	for _, mapping := range configuration.Get().UserRoles {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) == 2 && parts[0] == username {
+			return parts[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package authenticator
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/NethServer/ns-api-server/configuration"
+)
+
+// provider accepting a fixed list of users
+type staticProvider struct {
+	name  string
+	users map[string]string
+}
+
+func (p *staticProvider) Name() string {
+	return p.name
+}
+
+func (p *staticProvider) Authenticate(username string, password string) error {
+	if expected, ok := p.users[username]; ok && expected == password {
+		return nil
+	}
+	return errors.New("invalid credentials")
+}
+
+func TestAuthenticateChain(t *testing.T) {
+	configuration.Set(configuration.Configuration{UserRoles: []string{"alice=auditor", "bob=admin", "root=auditor"}})
+	chain = []Authenticator{
+		&staticProvider{name: "ubus", users: map[string]string{"root": "root-secret", "admin": "admin-secret"}},
+		&staticProvider{name: "ldap", users: map[string]string{"alice": "alice-secret", "bob": "bob-secret", "carol": "carol-secret", "admin": "other-secret"}},
+	}
+	t.Cleanup(func() {
+		configuration.Set(configuration.Configuration{})
+		chain = nil
+	})
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		role     string
+		valid    bool
+	}{
+		{name: "system user without mapping is admin", username: "admin", password: "admin-secret", role: "admin", valid: true},
+		{name: "system user with mapping", username: "root", password: "root-secret", role: "auditor", valid: true},
+		{name: "directory user with mapping", username: "alice", password: "alice-secret", role: "auditor", valid: true},
+		{name: "directory user mapped as admin", username: "bob", password: "bob-secret", role: "admin", valid: true},
+		{name: "directory user without mapping has no access", username: "carol", password: "carol-secret"},
+		{name: "system user name from directory has no access", username: "admin", password: "other-secret"},
+		{name: "wrong password", username: "alice", password: "bob-secret"},
+		{name: "unknown user", username: "mallory", password: "secret"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			role, err := Authenticate(test.username, test.password)
+			if (err == nil) != test.valid {
+				t.Fatalf("authenticated is %v, expected %v: %v", err == nil, test.valid, err)
+			}
+			if role != test.role {
+				t.Errorf("role is %q, expected %q", role, test.role)
+			}
+		})
+	}
+}
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		providers []string
+		chain     []string
+		valid     bool
+	}{
+		{providers: []string{"ubus"}, chain: []string{"ubus"}, valid: true},
+		{providers: []string{"htpasswd", " ldap"}, chain: []string{"htpasswd", "ldap"}, valid: true},
+		{providers: []string{"ubus", "kerberos"}},
+		{providers: []string{}},
+	}
+
+	for _, test := range tests {
+		configuration.Set(configuration.Configuration{AuthProviders: test.providers})
+		err := Init()
+		if (err == nil) != test.valid {
+			t.Errorf("%v: valid is %v, expected %v: %v", test.providers, err == nil, test.valid, err)
+			continue
+		}
+		var names []string
+		for _, provider := range chain {
+			names = append(names, provider.Name())
+		}
+		if test.valid && len(names) != len(test.chain) {
+			t.Errorf("%v: chain is %v", test.providers, names)
+		}
+	}
+	configuration.Set(configuration.Configuration{})
+	chain = nil
+}
+
+func TestHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("alice-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	content := "# users\n\nalice:" + string(hash) + "\nbob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\nmalformed\n"
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		username string
+		password string
+		valid    bool
+	}{
+		{name: "valid credentials", file: file, username: "alice", password: "alice-secret", valid: true},
+		{name: "wrong password", file: file, username: "alice", password: "bob-secret"},
+		{name: "unsupported hash", file: file, username: "bob", password: "password"},
+		{name: "unknown user", file: file, username: "carol", password: "alice-secret"},
+		{name: "comment is not a user", file: file, username: "# users", password: ""},
+		{name: "missing file", file: file + ".missing", username: "alice", password: "alice-secret"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := (&Htpasswd{File: test.file}).Authenticate(test.username, test.password)
+			if (err == nil) != test.valid {
+				t.Errorf("authenticated is %v, expected %v: %v", err == nil, test.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package authenticator
+
+import (
+	"crypto/x509"
+	"strings"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/models"
+)
+
+func certificateNames(cert *x509.Certificate) []string {
+	// collect subject common name and alternative names
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+func CertificateUser(cert *x509.Certificate) (*models.UserAuthorizations, bool) {
+	// search mapping for certificate names, in mapping order
+	names := certificateNames(cert)
+	for _, mapping := range configuration.Get().TLSClientUsers {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		for _, name := range names {
+			if name != "" && name == parts[0] {
+				// read user and optional role
+				user := strings.SplitN(parts[1], ":", 2)
+				role := "admin"
+				if len(user) == 2 && user[1] != "" {
+					role = user[1]
+				}
+				return &models.UserAuthorizations{
+					Username: user[0],
+					Role:     role,
+				}, true
+			}
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package authenticator
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Htpasswd checks credentials against a htpasswd file with bcrypt hashes,
+// the file is read on every login so changes apply without restart
+type Htpasswd struct {
+	File string
+}
+
+func (h *Htpasswd) Name() string {
+	return "htpasswd"
+}
+
+func (h *Htpasswd) Authenticate(username string, password string) error {
+	// open htpasswd file
+	f, err := os.Open(h.File)
+	if err != nil {
+		return errors.Wrap(err, "htpasswd file read error")
+	}
+	defer f.Close()
+
+	// search user line
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] != username {
+			continue
+		}
+
+		// only bcrypt hashes are supported
+		if !strings.HasPrefix(parts[1], "$2") {
+			return errors.New("unsupported hash for user " + username)
+		}
+		if bcrypt.CompareHashAndPassword([]byte(parts[1]), []byte(password)) != nil {
+			return errors.New("invalid password")
+		}
+		return nil
+	}
+
+	return errors.New("user not found")
+}
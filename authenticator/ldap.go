@@ -0,0 +1,148 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package authenticator
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pkg/errors"
+)
+
+// LDAP checks credentials with a bind on the directory. When BindDN is set
+// the user DN is built from it, otherwise the user is searched below BaseDN
+// with UserFilter, using SearchDN credentials if given
+type LDAP struct {
+	URL            string
+	BindDN         string
+	BaseDN         string
+	UserFilter     string
+	SearchDN       string
+	SearchPassword string
+	StartTLS       bool
+	CAFile         string
+}
+
+func (l *LDAP) Name() string {
+	return "ldap"
+}
+
+func (l *LDAP) connect() (*ldap.Conn, error) {
+	// define tls configuration
+	tlsConfig := &tls.Config{}
+	if l.CAFile != "" {
+		caB, err := ioutil.ReadFile(l.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "ldap ca file read error")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		tlsConfig.RootCAs.AppendCertsFromPEM(caB)
+	}
+
+	// connect to directory
+	conn, err := ldap.DialURL(l.URL, ldap.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, errors.Wrap(err, "ldap connection error")
+	}
+	conn.SetTimeout(5 * time.Second)
+
+	// upgrade plain connection
+	if l.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "ldap starttls error")
+		}
+	}
+
+	return conn, nil
+}
+
+func (l *LDAP) userDN(conn *ldap.Conn, username string) (string, error) {
+	// build dn from template
+	if l.BindDN != "" {
+		return fmt.Sprintf(l.BindDN, escapeDN(username)), nil
+	}
+
+	// bind with search credentials
+	if l.SearchDN != "" {
+		if err := conn.Bind(l.SearchDN, l.SearchPassword); err != nil {
+			return "", errors.Wrap(err, "ldap search bind error")
+		}
+	}
+
+	// search user entry
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 5, false,
+		fmt.Sprintf(l.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn"},
+		nil,
+	))
+	if err != nil {
+		return "", errors.Wrap(err, "ldap search error")
+	}
+	if len(result.Entries) != 1 {
+		return "", errors.New("user not found")
+	}
+
+	return result.Entries[0].DN, nil
+}
+
+func (l *LDAP) Authenticate(username string, password string) error {
+	// empty password would be an unauthenticated bind
+	if password == "" {
+		return errors.New("empty password")
+	}
+
+	// connect to directory
+	conn, err := l.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// get user dn
+	dn, err := l.userDN(conn, username)
+	if err != nil {
+		return err
+	}
+
+	// bind as user
+	if err := conn.Bind(dn, password); err != nil {
+		return errors.Wrap(err, "ldap bind error")
+	}
+
+	return nil
+}
+
+func escapeDN(value string) string {
+	// escape special characters of a dn attribute value (RFC 4514)
+	var escaped strings.Builder
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(",+\"\\<>;=", r),
+			i == 0 && (r == ' ' || r == '#'),
+			i == len(value)-1 && r == ' ':
+			escaped.WriteRune('\\')
+			escaped.WriteRune(r)
+		case r == 0:
+			escaped.WriteString("\\00")
+		default:
+			escaped.WriteRune(r)
+		}
+	}
+	return escaped.String()
+}
@@ -0,0 +1,324 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package authenticator
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// ldap protocol operations and result codes used by the stand-in directory
+const (
+	ldapBindRequest      = 0
+	ldapBindResponse     = 1
+	ldapUnbindRequest    = 2
+	ldapSearchRequest    = 3
+	ldapSearchEntry      = 4
+	ldapSearchDone       = 5
+	ldapFilterAnd        = 0
+	ldapFilterEquality   = 3
+	ldapSuccess          = 0
+	ldapOperationsError  = 1
+	ldapNoSuchObject     = 32
+	ldapInvalidCreds     = 49
+	ldapInsufficientAuth = 50
+)
+
+type ldapEntry struct {
+	password   string
+	attributes map[string]string
+}
+
+// in-process directory, answering simple binds and equality searches
+type ldapServer struct {
+	listener net.Listener
+	entries  map[string]ldapEntry
+	mutex    sync.Mutex
+	binds    []string
+}
+
+func newLDAPServer(t *testing.T, entries map[string]ldapEntry) *ldapServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &ldapServer{listener: listener, entries: entries}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (s *ldapServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *ldapServer) Binds() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.binds...)
+}
+
+func (s *ldapServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	bound := ""
+	for {
+		request, err := ber.ReadPacket(conn)
+		if err != nil || len(request.Children) < 2 {
+			return
+		}
+		id := request.Children[0].Value.(int64)
+		operation := request.Children[1]
+
+		switch operation.Tag {
+		case ldapBindRequest:
+			dn := operation.Children[1].Data.String()
+			password := operation.Children[2].Data.String()
+			s.mutex.Lock()
+			s.binds = append(s.binds, dn)
+			s.mutex.Unlock()
+
+			code := ldapInvalidCreds
+			if entry, ok := s.entries[strings.ToLower(dn)]; ok && entry.password == password && password != "" {
+				code = ldapSuccess
+				bound = dn
+			} else if password == "" {
+				code = ldapInsufficientAuth
+			}
+			s.write(conn, id, ldapBindResponse, code, nil)
+		case ldapSearchRequest:
+			base := strings.ToLower(operation.Children[0].Data.String())
+			filter := operation.Children[6]
+			var found []string
+			for dn, entry := range s.entries {
+				if strings.HasSuffix(dn, ","+base) && matchFilter(filter, entry.attributes) {
+					found = append(found, dn)
+				}
+			}
+			if bound == "" && len(found) > 0 {
+				// anonymous search is not allowed by this directory
+				s.write(conn, id, ldapSearchDone, ldapOperationsError, nil)
+				continue
+			}
+			for _, dn := range found {
+				s.write(conn, id, ldapSearchEntry, -1, &dn)
+			}
+			code := ldapSuccess
+			if !s.exists(base) {
+				code = ldapNoSuchObject
+			}
+			s.write(conn, id, ldapSearchDone, code, nil)
+		case ldapUnbindRequest:
+			return
+		}
+	}
+}
+
+func (s *ldapServer) exists(base string) bool {
+	for dn := range s.entries {
+		if strings.HasSuffix(dn, ","+base) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchFilter(filter *ber.Packet, attributes map[string]string) bool {
+	switch filter.Tag {
+	case ldapFilterAnd:
+		for _, child := range filter.Children {
+			if !matchFilter(child, attributes) {
+				return false
+			}
+		}
+		return true
+	case ldapFilterEquality:
+		name := strings.ToLower(filter.Children[0].Data.String())
+		return attributes[name] == filter.Children[1].Data.String()
+	}
+	return false
+}
+
+func (s *ldapServer) write(conn net.Conn, id int64, operation ber.Tag, code int, dn *string) {
+	message := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "message")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "id"))
+
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, operation, nil, "response")
+	if dn != nil {
+		response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, *dn, "dn"))
+		response.AppendChild(ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes"))
+	} else {
+		response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "code"))
+		response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matched"))
+		response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "message"))
+	}
+	message.AppendChild(response)
+
+	_, _ = conn.Write(message.Bytes())
+}
+
+func testDirectory(t *testing.T) *ldapServer {
+	return newLDAPServer(t, map[string]ldapEntry{
+		"uid=alice,ou=people,dc=example,dc=org": {password: "alice-secret", attributes: map[string]string{"uid": "alice"}},
+		"uid=bob,ou=people,dc=example,dc=org":   {password: "bob-secret", attributes: map[string]string{"uid": "bob"}},
+		"uid=twin,ou=people,dc=example,dc=org":  {password: "twin-secret", attributes: map[string]string{"uid": "twin"}},
+		"cn=twin,ou=people,dc=example,dc=org":   {password: "twin-secret", attributes: map[string]string{"uid": "twin"}},
+		"cn=reader,ou=system,dc=example,dc=org": {password: "reader-secret", attributes: map[string]string{"cn": "reader"}},
+	})
+}
+
+func TestLDAPBindTemplate(t *testing.T) {
+	directory := testDirectory(t)
+	provider := &LDAP{URL: directory.URL(), BindDN: "uid=%s,ou=people,dc=example,dc=org"}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		valid    bool
+		bind     string
+	}{
+		{name: "valid credentials", username: "alice", password: "alice-secret", valid: true, bind: "uid=alice,ou=people,dc=example,dc=org"},
+		{name: "wrong password", username: "alice", password: "bob-secret", bind: "uid=alice,ou=people,dc=example,dc=org"},
+		{name: "unknown user", username: "mallory", password: "secret", bind: "uid=mallory,ou=people,dc=example,dc=org"},
+		{name: "empty password is not sent", username: "alice", password: ""},
+		{name: "dn injection is escaped", username: "reader,ou=system", password: "reader-secret", bind: `uid=reader\,ou\=system,ou=people,dc=example,dc=org`},
+		{name: "leading space is escaped", username: " alice", password: "alice-secret", bind: `uid=\ alice,ou=people,dc=example,dc=org`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := len(directory.Binds())
+			err := provider.Authenticate(test.username, test.password)
+			if (err == nil) != test.valid {
+				t.Errorf("authenticated is %v, expected %v: %v", err == nil, test.valid, err)
+			}
+
+			binds := directory.Binds()[before:]
+			if test.bind == "" && len(binds) > 0 {
+				t.Errorf("unexpected binds %q", binds)
+			}
+			if test.bind != "" && (len(binds) != 1 || binds[0] != test.bind) {
+				t.Errorf("binds are %q, expected %q", binds, test.bind)
+			}
+		})
+	}
+}
+
+func TestLDAPSearch(t *testing.T) {
+	directory := testDirectory(t)
+
+	tests := []struct {
+		name     string
+		provider LDAP
+		username string
+		password string
+		valid    bool
+	}{
+		{
+			name:     "found with search credentials",
+			provider: LDAP{SearchDN: "cn=reader,ou=system,dc=example,dc=org", SearchPassword: "reader-secret"},
+			username: "bob", password: "bob-secret", valid: true,
+		},
+		{
+			name:     "wrong password",
+			provider: LDAP{SearchDN: "cn=reader,ou=system,dc=example,dc=org", SearchPassword: "reader-secret"},
+			username: "bob", password: "alice-secret",
+		},
+		{
+			name:     "wrong search credentials",
+			provider: LDAP{SearchDN: "cn=reader,ou=system,dc=example,dc=org", SearchPassword: "guess"},
+			username: "bob", password: "bob-secret",
+		},
+		{
+			name:     "anonymous search refused by directory",
+			username: "bob", password: "bob-secret",
+		},
+		{
+			name:     "ambiguous user",
+			provider: LDAP{SearchDN: "cn=reader,ou=system,dc=example,dc=org", SearchPassword: "reader-secret"},
+			username: "twin", password: "twin-secret",
+		},
+		{
+			name:     "filter injection is escaped",
+			provider: LDAP{SearchDN: "cn=reader,ou=system,dc=example,dc=org", SearchPassword: "reader-secret"},
+			username: "*", password: "bob-secret",
+		},
+		{
+			name:     "custom filter",
+			provider: LDAP{SearchDN: "cn=reader,ou=system,dc=example,dc=org", SearchPassword: "reader-secret", UserFilter: "(&(uid=%s)(uid=alice))"},
+			username: "bob", password: "bob-secret",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := test.provider
+			provider.URL = directory.URL()
+			provider.BaseDN = "ou=people,dc=example,dc=org"
+			if provider.UserFilter == "" {
+				provider.UserFilter = "(uid=%s)"
+			}
+
+			err := provider.Authenticate(test.username, test.password)
+			if (err == nil) != test.valid {
+				t.Errorf("authenticated is %v, expected %v: %v", err == nil, test.valid, err)
+			}
+		})
+	}
+}
+
+func TestLDAPUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "ldap://" + listener.Addr().String()
+	listener.Close()
+
+	provider := &LDAP{URL: url, BindDN: "uid=%s,ou=people,dc=example,dc=org"}
+	if err := provider.Authenticate("alice", "alice-secret"); err == nil || !strings.Contains(err.Error(), "ldap connection error") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	tests := map[string]string{
+		"alice":      "alice",
+		"a,b":        `a\,b`,
+		"a+b=c":      `a\+b\=c`,
+		`"quoted"`:   `\"quoted\"`,
+		"#hash":      `\#hash`,
+		" padded ":   `\ padded\ `,
+		"a\x00b":     `a\00b`,
+		"back\\path": `back\\path`,
+		"<tag>;":     `\<tag\>\;`,
+	}
+	for value, expected := range tests {
+		if escaped := escapeDN(value); escaped != expected {
+			t.Errorf("escapeDN(%q) is %q, expected %q", value, escaped, expected)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package authenticator
+
+import (
+	"encoding/json"
+	"os/exec"
+
+	"github.com/NethServer/ns-api-server/metrics"
+	"github.com/NethServer/ns-api-server/models"
+)
+
+type Ubus struct{}
+
+func (u *Ubus) Name() string {
+	return "ubus"
+}
+
+func (u *Ubus) Authenticate(username string, password string) error {
+	// define login object
+	login := models.UserLogin{
+		Username: username,
+		Password: password,
+		Timeout:  1,
+	}
+	jsonLogin, _ := json.Marshal(login)
+
+	// execute login command on ubus
+	metrics.ExecsInFlight.Inc()
+	_, err := exec.Command("/bin/ubus", "call", "session", "login", string(jsonLogin)).Output()
+	metrics.ExecsInFlight.Dec()
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -10,71 +10,782 @@
 package configuration
 
 import (
+	"encoding/json"
+	"net"
 	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/utils"
 )
 
 type Configuration struct {
-	ListenAddress string `json:"listen_address"`
+	ListenAddress string     `json:"listen_address"`
+	Listeners     []Listener `json:"listeners"`
+
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
+	PidFile         string        `json:"pid_file"`
+
+	TLS              bool     `json:"tls"`
+	TLSCertFile      string   `json:"tls_cert_file"`
+	TLSKeyFile       string   `json:"tls_key_file"`
+	TLSMinVersion    string   `json:"tls_min_version"`
+	TLSCiphers       []string `json:"tls_ciphers"`
+	TLSRedirect      string   `json:"tls_redirect_address"`
+	TLSClientCAFile  string   `json:"tls_client_ca_file"`
+	TLSClientCRLFile string   `json:"tls_client_crl_file"`
+	TLSClientAuth    string   `json:"tls_client_auth"`
+	TLSClientUsers   []string `json:"tls_client_users"`
 
 	SecretJWT  string `json:"secret_jwt"`
 	Issuer2FA  string `json:"issuer_2fa"`
 	SecretsDir string `json:"secrets_dir"`
 	TokensDir  string `json:"tokens_dir"`
 
-	StaticDir string `json:"static_dir"`
+	SessionCookie         bool   `json:"session_cookie"`
+	SessionCookieName     string `json:"session_cookie_name"`
+	SessionCookieDomain   string `json:"session_cookie_domain"`
+	SessionCookieSameSite string `json:"session_cookie_same_site"`
+
+	JWTQueryLookup bool          `json:"jwt_query_lookup"`
+	TicketsTTL     time.Duration `json:"tickets_ttl"`
+
+	AuthProviders      []string `json:"auth_providers"`
+	UserRoles          []string `json:"user_roles"`
+	HtpasswdFile       string   `json:"htpasswd_file"`
+	LDAPURL            string   `json:"ldap_url"`
+	LDAPBindDN         string   `json:"ldap_bind_dn"`
+	LDAPBaseDN         string   `json:"ldap_base_dn"`
+	LDAPUserFilter     string   `json:"ldap_user_filter"`
+	LDAPSearchDN       string   `json:"ldap_search_dn"`
+	LDAPSearchPassword string   `json:"ldap_search_password"`
+	LDAPStartTLS       bool     `json:"ldap_start_tls"`
+	LDAPCAFile         string   `json:"ldap_ca_file"`
+
+	OIDCIssuer        string   `json:"oidc_issuer"`
+	OIDCClientID      string   `json:"oidc_client_id"`
+	OIDCClientSecret  string   `json:"oidc_client_secret"`
+	OIDCRedirectURL   string   `json:"oidc_redirect_url"`
+	OIDCScopes        []string `json:"oidc_scopes"`
+	OIDCUsernameClaim string   `json:"oidc_username_claim"`
+	OIDCGroupsClaim   string   `json:"oidc_groups_claim"`
+	OIDCUserMapping   []string `json:"oidc_user_mapping"`
+	OIDCRoleMapping   []string `json:"oidc_role_mapping"`
+	OIDCDefaultRole   string   `json:"oidc_default_role"`
+	OIDCPostLoginURL  string   `json:"oidc_post_login_url"`
+
+	JWTAlgorithm      string `json:"jwt_algorithm"`
+	JWTPrivateKeyFile string `json:"jwt_private_key_file"`
+
+	KeysDir         string        `json:"keys_dir"`
+	KeysRetireAfter time.Duration `json:"keys_retire_after"`
+
+	StaticDir              string `json:"static_dir"`
+	StaticImmutablePattern string `json:"static_immutable_pattern"`
+
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	UBusRequestIDObjects []string `json:"ubus_request_id_objects"`
+
+	OpenAPIExplorer    bool `json:"openapi_explorer"`
+	OpenAPIUBusSchemas bool `json:"openapi_ubus_schemas"`
+
+	APILegacyDeprecation time.Time `json:"api_legacy_deprecation"`
+	APILegacySunset      time.Time `json:"api_legacy_sunset"`
+
+	RateLimits []string `json:"rate_limits"`
+
+	CORSAllowedOrigins   []string      `json:"cors_allowed_origins"`
+	CORSAllowCredentials bool          `json:"cors_allow_credentials"`
+	CORSMaxAge           time.Duration `json:"cors_max_age"`
+
+	SecurityCSP          string        `json:"security_csp"`
+	SecurityHSTSMaxAge   time.Duration `json:"security_hsts_max_age"`
+	SecurityFrameOptions string        `json:"security_frame_options"`
+
+	MetricsToken           string   `json:"metrics_token"`
+	MetricsAllowedNetworks []string `json:"metrics_allowed_networks"`
+
+	SensitiveList     []string `json:"sensitive_list"`
+	RedactKeyPatterns []string `json:"redact_key_patterns"`
+	RedactRules       []string `json:"redact_rules"`
+
+	AuditFile         string `json:"audit_file"`
+	AuditRemote       string `json:"audit_remote"`
+	AuditRemoteCAFile string `json:"audit_remote_ca_file"`
+
+	LogSinks          []string `json:"log_sinks"`
+	LogLevel          string   `json:"log_level"`
+	LogLevels         []string `json:"log_levels"`
+	LogFile           string   `json:"log_file"`
+	LogFileMaxSize    int      `json:"log_file_max_size"`
+	LogFileMaxBackups int      `json:"log_file_max_backups"`
+}
+
+// current configuration, replaced as a whole on reload
+var current atomic.Value
+
+// validation errors, reported all together
+type ValidationError []string
+
+func (errs ValidationError) Error() string {
+	return strings.Join(errs, "\n")
+}
+
+// configuration file, read again on reload
+var configFile string
+
+// functions called after a configuration reload
+var reloadHooks []func()
+
+// cookie names, as tokens of RFC 6265
+var cookieName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func init() {
+	current.Store(&Configuration{})
+}
+
+// current configuration, shared by all goroutines: it must not be modified
+func Get() *Configuration {
+	return current.Load().(*Configuration)
+}
+
+func Set(config Configuration) {
+	current.Store(&config)
+}
+
+func Init(file string) {
+	// read configuration, exit on invalid values
+	config, err := Load(file)
+	if err != nil {
+		for _, message := range strings.Split(err.Error(), "\n") {
+			logs.For("ENV").Crit(message)
+		}
+		os.Exit(1)
+	}
+
+	Set(config)
+	configFile = file
+}
 
-	SensitiveList []string `json:"sensitive_list"`
+func OnReload(hook func()) {
+	reloadHooks = append(reloadHooks, hook)
 }
 
-var Config = Configuration{}
+func Reload() error {
+	// read configuration, keep the current one if invalid
+	config, err := Load(configFile)
+	if err != nil {
+		return err
+	}
+
+	// apply only settings that are safe to change at runtime, on a copy read by new requests
+	next := *Get()
+	next.Issuer2FA = config.Issuer2FA
+	next.TLSClientUsers = config.TLSClientUsers
+	next.UserRoles = config.UserRoles
+	next.OIDCUserMapping = config.OIDCUserMapping
+	next.OIDCRoleMapping = config.OIDCRoleMapping
+	next.OIDCDefaultRole = config.OIDCDefaultRole
+	next.KeysRetireAfter = config.KeysRetireAfter
+	next.ShutdownTimeout = config.ShutdownTimeout
+	next.SensitiveList = config.SensitiveList
+	next.RedactKeyPatterns = config.RedactKeyPatterns
+	next.RedactRules = config.RedactRules
+	next.LogLevel = config.LogLevel
+	next.LogLevels = config.LogLevels
+	next.UBusRequestIDObjects = config.UBusRequestIDObjects
+	next.OpenAPIUBusSchemas = config.OpenAPIUBusSchemas
+	next.APILegacyDeprecation = config.APILegacyDeprecation
+	next.APILegacySunset = config.APILegacySunset
+	next.TicketsTTL = config.TicketsTTL
+	next.RateLimits = config.RateLimits
+	next.CORSAllowedOrigins = config.CORSAllowedOrigins
+	next.CORSAllowCredentials = config.CORSAllowCredentials
+	next.CORSMaxAge = config.CORSMaxAge
+	next.SecurityCSP = config.SecurityCSP
+	next.SecurityHSTSMaxAge = config.SecurityHSTSMaxAge
+	next.SecurityFrameOptions = config.SecurityFrameOptions
+	next.MetricsToken = config.MetricsToken
+	next.MetricsAllowedNetworks = config.MetricsAllowedNetworks
+
+	Set(next)
 
-func Init() {
-	// read configuration from ENV
-	if os.Getenv("LISTEN_ADDRESS") != "" {
-		Config.ListenAddress = os.Getenv("LISTEN_ADDRESS")
+	if !reflect.DeepEqual(config, next) {
+		logs.For("ENV").Warning("configuration reloaded, some changes require a restart")
 	} else {
-		Config.ListenAddress = "127.0.0.1:8080"
+		logs.For("ENV").Info("configuration reloaded")
+	}
+
+	// notify subsystems
+	for _, hook := range reloadHooks {
+		hook()
 	}
 
-	if os.Getenv("SECRET_JWT") != "" {
-		Config.SecretJWT = os.Getenv("SECRET_JWT")
+	return nil
+}
+
+// options masked in configuration summary
+var secretOptions = []string{"secret_jwt", "ldap_search_password", "oidc_client_secret", "metrics_token"}
+
+func Summary() map[string]interface{} {
+	// convert configuration to options map
+	summary := map[string]interface{}{}
+	configJSON, _ := json.Marshal(Get())
+	_ = json.Unmarshal(configJSON, &summary)
+
+	// mask secrets, keeping whether they are set
+	for _, option := range secretOptions {
+		if value, _ := summary[option].(string); value != "" {
+			summary[option] = "XXX"
+		}
+	}
+
+	return summary
+}
+
+func Load(file string) (Configuration, error) {
+	// read configuration file, ENV variables take precedence
+	var errs ValidationError
+	values, err := readFile(file)
+	if fileErrs, ok := err.(ValidationError); ok {
+		errs = append(errs, fileErrs...)
+	} else if err != nil {
+		return Configuration{}, err
+	}
+	getenv := func(name string) string {
+		if os.Getenv(name) != "" {
+			return os.Getenv(name)
+		}
+		return values[strings.ToLower(name)]
+	}
+
+	config := Configuration{}
+
+	// read configuration values
+	if getenv("LISTEN_ADDRESS") != "" {
+		config.ListenAddress = getenv("LISTEN_ADDRESS")
 	} else {
-		logs.Logs.Crit("[CRITICAL][ENV] SECRET_JWT variable is empty")
-		os.Exit(1)
+		config.ListenAddress = "127.0.0.1:8080"
 	}
 
-	if os.Getenv("ISSUER_2FA") != "" {
-		Config.Issuer2FA = os.Getenv("ISSUER_2FA")
+	if getenv("SHUTDOWN_TIMEOUT") != "" {
+		shutdownTimeout, err := time.ParseDuration(getenv("SHUTDOWN_TIMEOUT"))
+		if err != nil {
+			errs = append(errs, "SHUTDOWN_TIMEOUT variable is invalid: "+err.Error())
+		}
+		config.ShutdownTimeout = shutdownTimeout
 	} else {
-		Config.Issuer2FA = "NethServer"
+		config.ShutdownTimeout = time.Second * 30
 	}
 
-	if os.Getenv("SECRETS_DIR") != "" {
-		Config.SecretsDir = os.Getenv("SECRETS_DIR")
+	config.PidFile = getenv("PID_FILE")
+
+	config.TLSCertFile = getenv("TLS_CERT_FILE")
+	config.TLSKeyFile = getenv("TLS_KEY_FILE")
+	config.TLSRedirect = getenv("TLS_REDIRECT_ADDRESS")
+	config.TLSClientCAFile = getenv("TLS_CLIENT_CA_FILE")
+	config.TLSClientCRLFile = getenv("TLS_CLIENT_CRL_FILE")
+
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		errs = append(errs, "TLS_CERT_FILE and TLS_KEY_FILE variables must be both set")
+	}
+
+	if getenv("TLS") != "" {
+		config.TLS = getenv("TLS") == "1" || getenv("TLS") == "true"
 	} else {
-		logs.Logs.Crit("[CRITICAL][ENV] SECRETS_DIR variable is empty")
-		os.Exit(1)
+		config.TLS = config.TLSCertFile != ""
 	}
 
-	if os.Getenv("TOKENS_DIR") != "" {
-		Config.TokensDir = os.Getenv("TOKENS_DIR")
+	if getenv("LISTENERS") != "" {
+		for _, value := range strings.Split(getenv("LISTENERS"), ",") {
+			listener, err := parseListener(value)
+			if err != nil {
+				errs = append(errs, "LISTENERS variable is invalid: "+err.Error())
+			}
+			config.Listeners = append(config.Listeners, listener)
+
+			// enable tls settings when used by any listener
+			config.TLS = config.TLS || listener.TLS
+		}
+	} else if activated := activatedListeners(config.TLS); len(activated) > 0 {
+		config.Listeners = activated
 	} else {
-		logs.Logs.Crit("[CRITICAL][ENV] TOKENS_DIR variable is empty")
-		os.Exit(1)
+		config.Listeners = []Listener{{Network: "tcp", Address: config.ListenAddress, TLS: config.TLS, Routes: RouteGroups}}
+	}
+
+	if config.TLS && config.TLSCertFile == "" {
+		config.TLSCertFile = "/etc/ns-api-server/tls/cert.pem"
+		config.TLSKeyFile = "/etc/ns-api-server/tls/key.pem"
+	}
+
+	if config.TLSClientCAFile != "" && !config.TLS {
+		errs = append(errs, "TLS_CLIENT_CA_FILE variable requires TLS")
+	}
+
+	if getenv("TLS_MIN_VERSION") != "" {
+		config.TLSMinVersion = getenv("TLS_MIN_VERSION")
+	} else {
+		config.TLSMinVersion = "1.2"
+	}
+
+	if config.TLSMinVersion != "1.2" && config.TLSMinVersion != "1.3" {
+		errs = append(errs, "TLS_MIN_VERSION variable is invalid: must be 1.2 or 1.3")
+	}
+
+	if getenv("TLS_CIPHERS") != "" {
+		config.TLSCiphers = strings.Split(getenv("TLS_CIPHERS"), ",")
+	}
+
+	if getenv("TLS_CLIENT_AUTH") != "" {
+		config.TLSClientAuth = getenv("TLS_CLIENT_AUTH")
+	} else {
+		config.TLSClientAuth = "optional"
+	}
+
+	if config.TLSClientAuth != "optional" && config.TLSClientAuth != "require" {
+		errs = append(errs, "TLS_CLIENT_AUTH variable is invalid: must be optional or require")
+	}
+
+	if getenv("TLS_CLIENT_USERS") != "" {
+		config.TLSClientUsers = strings.Split(getenv("TLS_CLIENT_USERS"), ",")
+	}
+
+	if getenv("JWT_ALGORITHM") != "" {
+		config.JWTAlgorithm = getenv("JWT_ALGORITHM")
+	} else {
+		config.JWTAlgorithm = "HS256"
+	}
+
+	if config.JWTAlgorithm != "HS256" && config.JWTAlgorithm != "EdDSA" && config.JWTAlgorithm != "RS256" {
+		errs = append(errs, "JWT_ALGORITHM variable is invalid: must be HS256, EdDSA or RS256")
+	}
+
+	if getenv("JWT_PRIVATE_KEY_FILE") != "" {
+		config.JWTPrivateKeyFile = getenv("JWT_PRIVATE_KEY_FILE")
+	}
+
+	if getenv("SECRET_JWT") != "" {
+		config.SecretJWT = getenv("SECRET_JWT")
+	} else if config.JWTAlgorithm == "HS256" {
+		errs = append(errs, "SECRET_JWT variable is empty")
+	}
+
+	if getenv("ISSUER_2FA") != "" {
+		config.Issuer2FA = getenv("ISSUER_2FA")
+	} else {
+		config.Issuer2FA = "NethServer"
+	}
+
+	config.SessionCookie = getenv("SESSION_COOKIE") == "1" || getenv("SESSION_COOKIE") == "true"
+	config.SessionCookieDomain = getenv("SESSION_COOKIE_DOMAIN")
+
+	if getenv("SESSION_COOKIE_NAME") != "" {
+		config.SessionCookieName = getenv("SESSION_COOKIE_NAME")
+	} else {
+		config.SessionCookieName = "ns_api_session"
+	}
+
+	if !cookieName.MatchString(config.SessionCookieName) {
+		errs = append(errs, "SESSION_COOKIE_NAME variable is invalid: use letters, numbers, _ and -")
+	}
+
+	if getenv("SESSION_COOKIE_SAME_SITE") != "" {
+		config.SessionCookieSameSite = getenv("SESSION_COOKIE_SAME_SITE")
+	} else {
+		config.SessionCookieSameSite = "strict"
+	}
+
+	if !utils.Contains(config.SessionCookieSameSite, []string{"strict", "lax"}) {
+		errs = append(errs, "SESSION_COOKIE_SAME_SITE variable is invalid: must be strict or lax")
+	}
+
+	config.JWTQueryLookup = getenv("JWT_QUERY_LOOKUP") == "1" || getenv("JWT_QUERY_LOOKUP") == "true"
+
+	if getenv("TICKETS_TTL") != "" {
+		ttl, err := time.ParseDuration(getenv("TICKETS_TTL"))
+		if err != nil || ttl <= 0 || ttl > 5*time.Minute {
+			errs = append(errs, "TICKETS_TTL variable is invalid: must be a duration up to 5m")
+		}
+		config.TicketsTTL = ttl
+	} else {
+		config.TicketsTTL = 30 * time.Second
+	}
+
+	if getenv("SECRETS_DIR") != "" {
+		config.SecretsDir = getenv("SECRETS_DIR")
+	} else {
+		errs = append(errs, "SECRETS_DIR variable is empty")
+	}
+
+	if getenv("TOKENS_DIR") != "" {
+		config.TokensDir = getenv("TOKENS_DIR")
+	} else {
+		errs = append(errs, "TOKENS_DIR variable is empty")
+	}
+
+	if getenv("AUTH_PROVIDERS") != "" {
+		config.AuthProviders = strings.Split(getenv("AUTH_PROVIDERS"), ",")
+	} else {
+		config.AuthProviders = []string{"ubus"}
+	}
+
+	if getenv("USER_ROLES") != "" {
+		config.UserRoles = strings.Split(getenv("USER_ROLES"), ",")
+	}
+
+	if getenv("HTPASSWD_FILE") != "" {
+		config.HtpasswdFile = getenv("HTPASSWD_FILE")
+	} else {
+		config.HtpasswdFile = "/etc/ns-api-server/htpasswd"
+	}
+
+	if getenv("LDAP_URL") != "" {
+		config.LDAPURL = getenv("LDAP_URL")
+	} else if utils.Contains("ldap", config.AuthProviders) {
+		errs = append(errs, "LDAP_URL variable is empty")
+	}
+
+	config.LDAPBindDN = getenv("LDAP_BIND_DN")
+	config.LDAPBaseDN = getenv("LDAP_BASE_DN")
+	config.LDAPSearchDN = getenv("LDAP_SEARCH_DN")
+	config.LDAPSearchPassword = getenv("LDAP_SEARCH_PASSWORD")
+	config.LDAPStartTLS = getenv("LDAP_START_TLS") == "1" || getenv("LDAP_START_TLS") == "true"
+	config.LDAPCAFile = getenv("LDAP_CA_FILE")
+
+	if getenv("LDAP_USER_FILTER") != "" {
+		config.LDAPUserFilter = getenv("LDAP_USER_FILTER")
+	} else {
+		config.LDAPUserFilter = "(uid=%s)"
+	}
+
+	if utils.Contains("ldap", config.AuthProviders) && config.LDAPBindDN == "" && config.LDAPBaseDN == "" {
+		errs = append(errs, "LDAP_BIND_DN or LDAP_BASE_DN variable must be set")
+	}
+
+	if getenv("OIDC_ISSUER") != "" {
+		config.OIDCIssuer = getenv("OIDC_ISSUER")
+
+		if getenv("OIDC_CLIENT_ID") != "" {
+			config.OIDCClientID = getenv("OIDC_CLIENT_ID")
+		} else {
+			errs = append(errs, "OIDC_CLIENT_ID variable is empty")
+		}
+
+		if getenv("OIDC_REDIRECT_URL") != "" {
+			config.OIDCRedirectURL = getenv("OIDC_REDIRECT_URL")
+		} else {
+			errs = append(errs, "OIDC_REDIRECT_URL variable is empty")
+		}
+	}
+
+	config.OIDCClientSecret = getenv("OIDC_CLIENT_SECRET")
+	config.OIDCDefaultRole = getenv("OIDC_DEFAULT_ROLE")
+	config.OIDCPostLoginURL = getenv("OIDC_POST_LOGIN_URL")
+
+	if getenv("OIDC_SCOPES") != "" {
+		config.OIDCScopes = strings.Split(getenv("OIDC_SCOPES"), ",")
+	} else {
+		config.OIDCScopes = []string{"openid", "profile", "email", "groups"}
+	}
+
+	if getenv("OIDC_USERNAME_CLAIM") != "" {
+		config.OIDCUsernameClaim = getenv("OIDC_USERNAME_CLAIM")
+	} else {
+		config.OIDCUsernameClaim = "sub"
+	}
+
+	if getenv("OIDC_GROUPS_CLAIM") != "" {
+		config.OIDCGroupsClaim = getenv("OIDC_GROUPS_CLAIM")
+	} else {
+		config.OIDCGroupsClaim = "groups"
+	}
+
+	if getenv("OIDC_USER_MAPPING") != "" {
+		config.OIDCUserMapping = strings.Split(getenv("OIDC_USER_MAPPING"), ",")
+	}
+
+	for _, mapping := range config.OIDCUserMapping {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || !utils.ValidUsername(parts[1]) {
+			errs = append(errs, "OIDC_USER_MAPPING variable is invalid: "+mapping+" must be <username>=<local user>, with letters, numbers, ., _ and - in local user")
+		}
+	}
+
+	if getenv("OIDC_ROLE_MAPPING") != "" {
+		config.OIDCRoleMapping = strings.Split(getenv("OIDC_ROLE_MAPPING"), ",")
+	}
+
+	if getenv("KEYS_DIR") != "" {
+		config.KeysDir = getenv("KEYS_DIR")
+	} else {
+		config.KeysDir = "/etc/ns-api-server/keys"
+	}
+
+	if getenv("KEYS_RETIRE_AFTER") != "" {
+		retireAfter, err := time.ParseDuration(getenv("KEYS_RETIRE_AFTER"))
+		if err != nil {
+			errs = append(errs, "KEYS_RETIRE_AFTER variable is invalid: "+err.Error())
+		}
+		config.KeysRetireAfter = retireAfter
+	} else {
+		config.KeysRetireAfter = time.Hour * 48 // 2 days
+	}
+
+	if getenv("STATIC_DIR") != "" {
+		config.StaticDir = getenv("STATIC_DIR")
+	} else {
+		config.StaticDir = "/var/run/ns-api-server"
 	}
 
-	if os.Getenv("STATIC_DIR") != "" {
-		Config.StaticDir = os.Getenv("STATIC_DIR")
+	if getenv("STATIC_IMMUTABLE_PATTERN") != "" {
+		config.StaticImmutablePattern = getenv("STATIC_IMMUTABLE_PATTERN")
 	} else {
-		Config.StaticDir = "/var/run/ns-api-server"
+		config.StaticImmutablePattern = `[.-][A-Za-z0-9_]{8,}\.(js|mjs|css|woff2?|ttf|svg|png|jpe?g|gif|webp|avif|wasm)$`
+	}
+
+	if _, err := regexp.Compile(config.StaticImmutablePattern); err != nil {
+		errs = append(errs, "STATIC_IMMUTABLE_PATTERN variable is invalid: "+err.Error())
 	}
 
-	if os.Getenv("SENSITIVE_LIST") != "" {
-		Config.SensitiveList = strings.Split(os.Getenv("SENSITIVE_LIST"), ",")
+	if getenv("TRUSTED_PROXIES") != "" {
+		config.TrustedProxies = strings.Split(getenv("TRUSTED_PROXIES"), ",")
 	} else {
-		Config.SensitiveList = []string{"password", "secret", "token"}
+		config.TrustedProxies = []string{"127.0.0.1", "::1"}
+	}
+
+	if getenv("UBUS_REQUEST_ID_OBJECTS") != "" {
+		config.UBusRequestIDObjects = strings.Split(getenv("UBUS_REQUEST_ID_OBJECTS"), ",")
+	}
+
+	for _, pattern := range config.UBusRequestIDObjects {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, "UBUS_REQUEST_ID_OBJECTS variable is invalid: "+pattern+" is not a valid pattern")
+		}
+	}
+
+	config.OpenAPIExplorer = getenv("OPENAPI_EXPLORER") == "1" || getenv("OPENAPI_EXPLORER") == "true"
+	config.OpenAPIUBusSchemas = getenv("OPENAPI_UBUS_SCHEMAS") == "1" || getenv("OPENAPI_UBUS_SCHEMAS") == "true"
+
+	if getenv("API_LEGACY_DEPRECATION") != "" {
+		deprecation, err := parseDate(getenv("API_LEGACY_DEPRECATION"))
+		if err != nil {
+			errs = append(errs, "API_LEGACY_DEPRECATION variable is invalid: "+err.Error())
+		}
+		config.APILegacyDeprecation = deprecation
+	}
+
+	if getenv("API_LEGACY_SUNSET") != "" {
+		sunset, err := parseDate(getenv("API_LEGACY_SUNSET"))
+		if err != nil {
+			errs = append(errs, "API_LEGACY_SUNSET variable is invalid: "+err.Error())
+		}
+		if sunset.Before(config.APILegacyDeprecation) {
+			errs = append(errs, "API_LEGACY_SUNSET variable is invalid: sunset is before deprecation")
+		}
+		config.APILegacySunset = sunset
+	}
+
+	if getenv("RATE_LIMITS") != "" {
+		config.RateLimits = strings.Split(getenv("RATE_LIMITS"), ",")
+	}
+
+	for _, value := range config.RateLimits {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || !utils.Contains(parts[0], []string{"login", "otp", "read", "write"}) || !validRate(parts[1]) {
+			errs = append(errs, "RATE_LIMITS variable is invalid: "+value+" must be <group>=<requests>/<period> or <group>=off")
+		}
+	}
+
+	if getenv("CORS_ALLOWED_ORIGINS") != "" {
+		config.CORSAllowedOrigins = strings.Split(getenv("CORS_ALLOWED_ORIGINS"), ",")
+	}
+
+	for _, origin := range config.CORSAllowedOrigins {
+		if origin == "*" {
+			if len(config.CORSAllowedOrigins) > 1 {
+				errs = append(errs, "CORS_ALLOWED_ORIGINS variable is invalid: * must be the only origin")
+			}
+			continue
+		}
+		if !(strings.HasPrefix(origin, "http://") || strings.HasPrefix(origin, "https://")) || strings.Count(origin, "*") > 1 || strings.HasSuffix(origin, "/") {
+			errs = append(errs, "CORS_ALLOWED_ORIGINS variable is invalid: "+origin+" must be like https://host[:port], with at most one *")
+		}
+	}
+
+	config.CORSAllowCredentials = getenv("CORS_ALLOW_CREDENTIALS") == "1" || getenv("CORS_ALLOW_CREDENTIALS") == "true"
+	if config.CORSAllowCredentials && utils.Contains("*", config.CORSAllowedOrigins) {
+		errs = append(errs, "CORS_ALLOW_CREDENTIALS variable is invalid: credentials can't be allowed to all origins")
+	}
+
+	if getenv("CORS_MAX_AGE") != "" {
+		maxAge, err := time.ParseDuration(getenv("CORS_MAX_AGE"))
+		if err != nil || maxAge < 0 {
+			errs = append(errs, "CORS_MAX_AGE variable is invalid: must be a duration, like 12h")
+		}
+		config.CORSMaxAge = maxAge
+	} else {
+		config.CORSMaxAge = 12 * time.Hour
+	}
+
+	if getenv("SECURITY_CSP") != "" {
+		config.SecurityCSP = getenv("SECURITY_CSP")
+	} else {
+		config.SecurityCSP = "default-src 'self'; img-src 'self' data:; style-src 'self' 'unsafe-inline'; frame-ancestors 'none'; base-uri 'self'; form-action 'self'"
+	}
+
+	if getenv("SECURITY_HSTS_MAX_AGE") != "" {
+		maxAge, err := time.ParseDuration(getenv("SECURITY_HSTS_MAX_AGE"))
+		if err != nil || maxAge < 0 {
+			errs = append(errs, "SECURITY_HSTS_MAX_AGE variable is invalid: must be a duration, like 8760h")
+		}
+		config.SecurityHSTSMaxAge = maxAge
+	} else {
+		config.SecurityHSTSMaxAge = 365 * 24 * time.Hour
+	}
+
+	if getenv("SECURITY_FRAME_OPTIONS") != "" {
+		config.SecurityFrameOptions = getenv("SECURITY_FRAME_OPTIONS")
+	} else {
+		config.SecurityFrameOptions = "DENY"
+	}
+
+	if !utils.Contains(config.SecurityFrameOptions, []string{"DENY", "SAMEORIGIN", "off"}) {
+		errs = append(errs, "SECURITY_FRAME_OPTIONS variable is invalid: must be DENY, SAMEORIGIN or off")
+	}
+
+	config.MetricsToken = getenv("METRICS_TOKEN")
+
+	if getenv("METRICS_ALLOWED_NETWORKS") != "" {
+		config.MetricsAllowedNetworks = strings.Split(getenv("METRICS_ALLOWED_NETWORKS"), ",")
+	} else {
+		config.MetricsAllowedNetworks = []string{"127.0.0.0/8", "::1"}
+	}
+
+	for _, network := range config.MetricsAllowedNetworks {
+		if _, _, err := net.ParseCIDR(network); err != nil && net.ParseIP(network) == nil {
+			errs = append(errs, "METRICS_ALLOWED_NETWORKS variable is invalid: "+network+" is not an address or network")
+		}
+	}
+
+	if getenv("SENSITIVE_LIST") != "" {
+		config.SensitiveList = strings.Split(getenv("SENSITIVE_LIST"), ",")
+	} else {
+		config.SensitiveList = []string{"password", "secret", "token"}
+	}
+
+	if getenv("REDACT_KEY_PATTERNS") != "" {
+		config.RedactKeyPatterns = strings.Split(getenv("REDACT_KEY_PATTERNS"), ",")
+	}
+
+	for _, expression := range config.RedactKeyPatterns {
+		if _, err := regexp.Compile(expression); err != nil {
+			errs = append(errs, "REDACT_KEY_PATTERNS variable is invalid: "+err.Error())
+		}
+	}
+
+	if getenv("REDACT_RULES") != "" {
+		config.RedactRules = strings.Split(getenv("REDACT_RULES"), ",")
+	}
+
+	for _, value := range config.RedactRules {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || !strings.Contains(parts[0], "/") || parts[1] == "" {
+			errs = append(errs, "REDACT_RULES variable is invalid: "+value+" must be <object>/<method>=<field>[+<field>...]")
+		}
+	}
+
+	if getenv("AUDIT_FILE") != "" {
+		config.AuditFile = getenv("AUDIT_FILE")
+	} else {
+		config.AuditFile = "/var/lib/ns-api-server/audit.jsonl"
+	}
+
+	config.AuditRemote = getenv("AUDIT_REMOTE")
+	config.AuditRemoteCAFile = getenv("AUDIT_REMOTE_CA_FILE")
+
+	if getenv("LOG_SINKS") != "" {
+		config.LogSinks = strings.Split(getenv("LOG_SINKS"), ",")
+	} else {
+		config.LogSinks = []string{"syslog"}
+	}
+
+	for _, sink := range config.LogSinks {
+		if sink != "syslog" && sink != "stderr" && sink != "file" {
+			errs = append(errs, "LOG_SINKS variable is invalid: unknown sink "+sink)
+		}
+	}
+
+	if getenv("LOG_LEVEL") != "" {
+		config.LogLevel = getenv("LOG_LEVEL")
+	} else {
+		config.LogLevel = "info"
+	}
+
+	if getenv("LOG_LEVELS") != "" {
+		config.LogLevels = strings.Split(getenv("LOG_LEVELS"), ",")
+	}
+
+	if _, _, err := logs.ParseLevels(config.LogLevel, config.LogLevels); err != nil {
+		errs = append(errs, "LOG_LEVEL or LOG_LEVELS variable is invalid: "+err.Error())
+	}
+
+	if getenv("LOG_FILE") != "" {
+		config.LogFile = getenv("LOG_FILE")
+	} else {
+		config.LogFile = "/var/log/ns-api-server.log"
+	}
+
+	if getenv("LOG_FILE_MAX_SIZE") != "" {
+		maxSize, err := strconv.Atoi(getenv("LOG_FILE_MAX_SIZE"))
+		if err != nil || maxSize < 0 {
+			errs = append(errs, "LOG_FILE_MAX_SIZE variable is invalid: must be a number of megabytes")
+		}
+		config.LogFileMaxSize = maxSize
+	} else {
+		config.LogFileMaxSize = 10
+	}
+
+	if getenv("LOG_FILE_MAX_BACKUPS") != "" {
+		maxBackups, err := strconv.Atoi(getenv("LOG_FILE_MAX_BACKUPS"))
+		if err != nil || maxBackups < 0 {
+			errs = append(errs, "LOG_FILE_MAX_BACKUPS variable is invalid: must be a number")
+		}
+		config.LogFileMaxBackups = maxBackups
+	} else {
+		config.LogFileMaxBackups = 5
+	}
+
+	if len(errs) > 0 {
+		return config, errs
+	}
+
+	return config, nil
+}
+
+func parseDate(value string) (time.Time, error) {
+	// accept a day or a full timestamp
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func validRate(value string) bool {
+	if value == "off" {
+		return true
+	}
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return false
 	}
+	requests, err := strconv.Atoi(parts[0])
+	period, errPeriod := time.ParseDuration(parts[1])
+	return err == nil && errPeriod == nil && requests > 0 && period > 0
 }
@@ -0,0 +1,388 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package configuration
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// variables required by every configuration
+var required = map[string]string{
+	"SECRET_JWT":  "secret",
+	"SECRETS_DIR": "/tmp/secrets",
+	"TOKENS_DIR":  "/tmp/tokens",
+}
+
+func setenv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for name, value := range env {
+		previous, exists := os.LookupEnv(name)
+		os.Setenv(name, value)
+		name := name
+		t.Cleanup(func() {
+			if exists {
+				os.Setenv(name, previous)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func merge(env map[string]string, extra map[string]string) map[string]string {
+	result := map[string]string{}
+	for name, value := range env {
+		result[name] = value
+	}
+	for name, value := range extra {
+		result[name] = value
+	}
+	return result
+}
+
+func TestMain(m *testing.M) {
+	// never read the configuration of the host
+	DefaultFile = "/nonexistent/ns-api-server"
+	os.Exit(m.Run())
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		errors []string
+	}{
+		{
+			name: "valid",
+			env:  required,
+		},
+		{
+			name: "required variables missing",
+			env:  map[string]string{},
+			errors: []string{
+				"SECRET_JWT variable is empty",
+				"SECRETS_DIR variable is empty",
+				"TOKENS_DIR variable is empty",
+			},
+		},
+		{
+			name: "secret not required by asymmetric keys",
+			env:  merge(required, map[string]string{"SECRET_JWT": "", "JWT_ALGORITHM": "EdDSA"}),
+		},
+		{
+			name: "invalid values reported together",
+			env: merge(required, map[string]string{
+				"TLS_MIN_VERSION":        "1.1",
+				"RATE_LIMITS":            "login=10/1m,search=1/1s,read=0/1m",
+				"SECURITY_FRAME_OPTIONS": "ALLOW",
+				"SHUTDOWN_TIMEOUT":       "soon",
+			}),
+			errors: []string{
+				`SHUTDOWN_TIMEOUT variable is invalid: time: invalid duration "soon"`,
+				"TLS_MIN_VERSION variable is invalid: must be 1.2 or 1.3",
+				"RATE_LIMITS variable is invalid: search=1/1s must be <group>=<requests>/<period> or <group>=off",
+				"RATE_LIMITS variable is invalid: read=0/1m must be <group>=<requests>/<period> or <group>=off",
+				"SECURITY_FRAME_OPTIONS variable is invalid: must be DENY, SAMEORIGIN or off",
+			},
+		},
+		{
+			name: "cors origins",
+			env: merge(required, map[string]string{
+				"CORS_ALLOWED_ORIGINS": "https://ui.example.com,ftp://files.example.com,https://*.*.example.com,https://slash.example.com/",
+			}),
+			errors: []string{
+				"CORS_ALLOWED_ORIGINS variable is invalid: ftp://files.example.com must be like https://host[:port], with at most one *",
+				"CORS_ALLOWED_ORIGINS variable is invalid: https://*.*.example.com must be like https://host[:port], with at most one *",
+				"CORS_ALLOWED_ORIGINS variable is invalid: https://slash.example.com/ must be like https://host[:port], with at most one *",
+			},
+		},
+		{
+			name: "cors wildcard with other origins and credentials",
+			env: merge(required, map[string]string{
+				"CORS_ALLOWED_ORIGINS":   "*,https://ui.example.com",
+				"CORS_ALLOW_CREDENTIALS": "1",
+			}),
+			errors: []string{
+				"CORS_ALLOWED_ORIGINS variable is invalid: * must be the only origin",
+				"CORS_ALLOW_CREDENTIALS variable is invalid: credentials can't be allowed to all origins",
+			},
+		},
+		{
+			name: "sunset before deprecation",
+			env: merge(required, map[string]string{
+				"API_LEGACY_DEPRECATION": "2024-06-01",
+				"API_LEGACY_SUNSET":      "2024-01-01",
+			}),
+			errors: []string{"API_LEGACY_SUNSET variable is invalid: sunset is before deprecation"},
+		},
+		{
+			name: "tls files",
+			env: merge(required, map[string]string{
+				"TLS_CERT_FILE":      "/etc/cert.pem",
+				"TLS_CLIENT_CA_FILE": "/etc/ca.pem",
+				"TLS":                "0",
+			}),
+			errors: []string{
+				"TLS_CERT_FILE and TLS_KEY_FILE variables must be both set",
+				"TLS_CLIENT_CA_FILE variable requires TLS",
+			},
+		},
+		{
+			name: "ldap provider",
+			env:  merge(required, map[string]string{"AUTH_PROVIDERS": "ubus,ldap"}),
+			errors: []string{
+				"LDAP_URL variable is empty",
+				"LDAP_BIND_DN or LDAP_BASE_DN variable must be set",
+			},
+		},
+		{
+			name: "oidc provider",
+			env:  merge(required, map[string]string{"OIDC_ISSUER": "https://id.example.com"}),
+			errors: []string{
+				"OIDC_CLIENT_ID variable is empty",
+				"OIDC_REDIRECT_URL variable is empty",
+			},
+		},
+		{
+			name: "oidc user mapping",
+			env:  merge(required, map[string]string{"OIDC_USER_MAPPING": "alice=root,bob=../root,carol"}),
+			errors: []string{
+				"OIDC_USER_MAPPING variable is invalid: bob=../root must be <username>=<local user>, with letters, numbers, ., _ and - in local user",
+				"OIDC_USER_MAPPING variable is invalid: carol must be <username>=<local user>, with letters, numbers, ., _ and - in local user",
+			},
+		},
+		{
+			name: "tickets ttl",
+			env:  merge(required, map[string]string{"TICKETS_TTL": "10m"}),
+			errors: []string{
+				"TICKETS_TTL variable is invalid: must be a duration up to 5m",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setenv(t, test.env)
+
+			_, err := Load("")
+			var errs ValidationError
+			if err != nil {
+				var ok bool
+				if errs, ok = err.(ValidationError); !ok {
+					t.Fatalf("unexpected error type %T: %v", err, err)
+				}
+			}
+			if !reflect.DeepEqual([]string(errs), test.errors) {
+				t.Errorf("errors are:\n%v\nexpected:\n%v", err, strings.Join(test.errors, "\n"))
+			}
+		})
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	setenv(t, required)
+
+	config, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.ListenAddress != "127.0.0.1:8080" || config.JWTAlgorithm != "HS256" || config.TicketsTTL != 30*time.Second {
+		t.Errorf("unexpected defaults: %+v", config)
+	}
+	if !reflect.DeepEqual(config.AuthProviders, []string{"ubus"}) {
+		t.Errorf("auth providers are %v", config.AuthProviders)
+	}
+	if len(config.Listeners) != 1 || config.Listeners[0].Address != "127.0.0.1:8080" {
+		t.Errorf("listeners are %+v", config.Listeners)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		env     map[string]string
+		check   func(Configuration) bool
+		errors  []string
+	}{
+		{
+			name:    "json",
+			file:    "config.json",
+			content: `{"secret_jwt": "secret", "secrets_dir": "/tmp/secrets", "tokens_dir": "/tmp/tokens", "user_roles": ["admin=admin", "bob=auditor"]}`,
+			check: func(config Configuration) bool {
+				return reflect.DeepEqual(config.UserRoles, []string{"admin=admin", "bob=auditor"})
+			},
+		},
+		{
+			name:    "yaml",
+			file:    "config.yaml",
+			content: "secret_jwt: secret\nsecrets_dir: /tmp/secrets\ntokens_dir: /tmp/tokens\nlog_level: debug\n",
+			check: func(config Configuration) bool {
+				return config.LogLevel == "debug"
+			},
+		},
+		{
+			name: "uci",
+			file: "ns-api-server",
+			content: `
+config server 'main'
+	option secret_jwt 'secret' # inline comment
+	option secrets_dir "/tmp/secrets"
+	option tokens_dir /tmp/tokens
+	list trusted_proxies '10.0.0.1'
+	list trusted_proxies '10.0.0.2'
+
+config other 'ignored'
+	option log_level 'nothing'
+`,
+			check: func(config Configuration) bool {
+				return reflect.DeepEqual(config.TrustedProxies, []string{"10.0.0.1", "10.0.0.2"})
+			},
+		},
+		{
+			name:    "variables take precedence",
+			file:    "config.json",
+			content: `{"secret_jwt": "secret", "secrets_dir": "/tmp/secrets", "tokens_dir": "/tmp/tokens", "log_level": "debug"}`,
+			env:     map[string]string{"LOG_LEVEL": "warning"},
+			check: func(config Configuration) bool {
+				return config.LogLevel == "warning"
+			},
+		},
+		{
+			name:    "unknown options and invalid values reported together",
+			file:    "config.json",
+			content: `{"secret_jwt": "secret", "secrets_dir": "/tmp/secrets", "tokens_dir": "/tmp/tokens", "log_sinks": ["stderr", "pipe"], "tls_min_versoin": "1.3"}`,
+			errors: []string{
+				"configuration file FILE has unknown option tls_min_versoin",
+				"LOG_SINKS variable is invalid: unknown sink pipe",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := writeFile(t, test.file, test.content)
+			setenv(t, test.env)
+
+			config, err := Load(file)
+			var errs []string
+			if err != nil {
+				validation, ok := err.(ValidationError)
+				if !ok {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				for _, message := range validation {
+					errs = append(errs, strings.Replace(message, file, "FILE", 1))
+				}
+			}
+			if !reflect.DeepEqual(errs, test.errors) {
+				t.Fatalf("errors are %q, expected %q", errs, test.errors)
+			}
+			if test.check != nil && !test.check(config) {
+				t.Errorf("unexpected configuration: %+v", config)
+			}
+		})
+	}
+}
+
+func TestLoadMalformedFile(t *testing.T) {
+	for name, content := range map[string]string{
+		"config.json":   `{"secret_jwt": `,
+		"config.yaml":   "secret_jwt: [",
+		"ns-api-server": "option 'unterminated",
+		"config.yml":    "listeners:\n  address: 127.0.0.1\n",
+	} {
+		if _, err := Load(writeFile(t, name, content)); err == nil {
+			t.Errorf("%v: malformed file accepted", name)
+		} else if _, ok := err.(ValidationError); ok {
+			t.Errorf("%v: malformed file reported as validation error: %v", name, err)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	file := writeFile(t, "config.json", `{"secret_jwt": "secret", "secrets_dir": "/tmp/secrets", "tokens_dir": "/tmp/tokens", "user_roles": ["bob=auditor"]}`)
+	config, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Set(config)
+	configFile = file
+	t.Cleanup(func() {
+		Set(Configuration{})
+		configFile = ""
+	})
+
+	// readers run while the configuration is reloaded, go test -race checks access
+	var wait sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					_ = len(Get().UserRoles) + len(Get().SecretsDir)
+				}
+			}
+		}()
+	}
+
+	// reloadable options change, the others are kept until restart
+	if err := ioutil.WriteFile(file, []byte(`{"secret_jwt": "secret", "secrets_dir": "/tmp/other", "tokens_dir": "/tmp/tokens", "user_roles": ["bob=admin"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wait.Wait()
+
+	if !reflect.DeepEqual(Get().UserRoles, []string{"bob=admin"}) {
+		t.Errorf("user roles not reloaded: %v", Get().UserRoles)
+	}
+	if Get().SecretsDir != "/tmp/secrets" {
+		t.Errorf("secrets dir changed without restart: %v", Get().SecretsDir)
+	}
+
+	// invalid configuration is not applied
+	if err := ioutil.WriteFile(file, []byte(`{"secrets_dir": "/tmp/secrets", "tokens_dir": "/tmp/tokens", "user_roles": ["bob=auditor"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reload(); err == nil {
+		t.Error("invalid configuration reloaded")
+	}
+	if !reflect.DeepEqual(Get().UserRoles, []string{"bob=admin"}) {
+		t.Errorf("invalid configuration applied: %v", Get().UserRoles)
+	}
+}
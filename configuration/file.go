@@ -0,0 +1,181 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package configuration
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// uci configuration used when no file is given
+var DefaultFile = "/etc/config/ns-api-server"
+
+func readFile(file string) (map[string]string, error) {
+	// use default file only if it exists
+	if file == "" {
+		if _, err := os.Stat(DefaultFile); err != nil {
+			return map[string]string{}, nil
+		}
+		file = DefaultFile
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "configuration file read error")
+	}
+
+	// detect format from extension
+	var values map[string]string
+	switch filepath.Ext(file) {
+	case ".json":
+		values, err = readObject(content, json.Unmarshal)
+	case ".yaml", ".yml":
+		values, err = readObject(content, yaml.Unmarshal)
+	default:
+		values, err = readUCI(string(content))
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "configuration file "+file+" malformed")
+	}
+
+	// keys are the json names of configuration fields
+	var errs ValidationError
+	known := map[string]bool{}
+	fields := reflect.TypeOf(Configuration{})
+	for i := 0; i < fields.NumField(); i++ {
+		known[fields.Field(i).Tag.Get("json")] = true
+	}
+	for key := range values {
+		if !known[key] {
+			errs = append(errs, "configuration file "+file+" has unknown option "+key)
+		}
+	}
+	if len(errs) > 0 {
+		return values, errs
+	}
+
+	return values, nil
+}
+
+func readObject(content []byte, unmarshal func([]byte, interface{}) error) (map[string]string, error) {
+	var object map[string]interface{}
+	if err := unmarshal(content, &object); err != nil {
+		return nil, err
+	}
+
+	// convert values to ENV format, lists are comma separated
+	values := map[string]string{}
+	for key, value := range object {
+		switch value := value.(type) {
+		case []interface{}:
+			var items []string
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		case map[string]interface{}:
+			return nil, errors.New("option " + key + " must not be an object")
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(value)
+		}
+	}
+
+	return values, nil
+}
+
+func readUCI(content string) (map[string]string, error) {
+	values := map[string]string{}
+	section := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		words, err := splitUCI(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(words) == 0 {
+			continue
+		}
+
+		// read options of server sections only
+		switch {
+		case words[0] == "config" && len(words) >= 2:
+			section = words[1]
+		case words[0] == "option" && len(words) == 3:
+			if section == "server" {
+				values[words[1]] = words[2]
+			}
+		case words[0] == "list" && len(words) == 3:
+			if section == "server" {
+				if values[words[1]] != "" {
+					values[words[1]] += ","
+				}
+				values[words[1]] += words[2]
+			}
+		default:
+			return nil, fmt.Errorf("line %d: invalid statement", line)
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+func splitUCI(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord := false
+
+	for _, char := range line {
+		switch {
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(char)
+		case char == '\'' || char == '"':
+			quote = char
+			inWord = true
+		case char == '#':
+			// comment until end of line
+			if inWord {
+				words = append(words, word.String())
+			}
+			return words, nil
+		case char == ' ' || char == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(char)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
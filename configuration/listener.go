@@ -0,0 +1,101 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package configuration
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/NethServer/ns-api-server/utils"
+)
+
+type Listener struct {
+	Network string      `json:"network"`
+	Address string      `json:"address"`
+	TLS     bool        `json:"tls"`
+	Routes  []string    `json:"routes"`
+	Mode    os.FileMode `json:"mode"`
+	Owner   string      `json:"owner"`
+}
+
+// route groups served by listeners
+var RouteGroups = []string{"api", "metrics", "static"}
+
+func parseListener(value string) (Listener, error) {
+	// listener format is <address>[;<option>...]
+	options := strings.Split(strings.TrimSpace(value), ";")
+	listener := Listener{Network: "tcp", Address: options[0], Routes: RouteGroups}
+
+	// read network from address prefix
+	for _, network := range []string{"tcp", "unix", "fd"} {
+		if strings.HasPrefix(listener.Address, network+":") {
+			listener.Network = network
+			listener.Address = strings.TrimPrefix(listener.Address, network+":")
+		}
+	}
+	if listener.Address == "" {
+		return Listener{}, fmt.Errorf("listener %s has no address", value)
+	}
+
+	// read options
+	for _, option := range options[1:] {
+		parts := strings.SplitN(option, "=", 2)
+		switch {
+		case parts[0] == "tls" && len(parts) == 1:
+			listener.TLS = true
+		case parts[0] == "routes" && len(parts) == 2:
+			listener.Routes = strings.Split(parts[1], "+")
+			for _, route := range listener.Routes {
+				if !utils.Contains(route, RouteGroups) {
+					return Listener{}, fmt.Errorf("listener %s has unknown route group %s", value, route)
+				}
+			}
+		case parts[0] == "mode" && len(parts) == 2:
+			mode, err := strconv.ParseUint(parts[1], 8, 32)
+			if err != nil {
+				return Listener{}, fmt.Errorf("listener %s has invalid mode %s", value, parts[1])
+			}
+			listener.Mode = os.FileMode(mode)
+		case parts[0] == "owner" && len(parts) == 2:
+			listener.Owner = parts[1]
+		default:
+			return Listener{}, fmt.Errorf("listener %s has unknown option %s", value, option)
+		}
+	}
+
+	// socket options apply only to unix sockets
+	if listener.Network != "unix" && (listener.Mode != 0 || listener.Owner != "") {
+		return Listener{}, fmt.Errorf("listener %s: mode and owner options require a unix socket", value)
+	}
+
+	return listener, nil
+}
+
+// number of sockets passed by systemd, or by the previous process on a listener handover
+func ActivatedSockets() int {
+	if os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()) {
+		count, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		return count
+	}
+	count, _ := strconv.Atoi(os.Getenv("HANDOVER_ACTIVATED_FDS"))
+	return count
+}
+
+func activatedListeners(tls bool) []Listener {
+	// first passed socket is always fd 3
+	var listeners []Listener
+	for fd := 3; fd < 3+ActivatedSockets(); fd++ {
+		listeners = append(listeners, Listener{Network: "fd", Address: strconv.Itoa(fd), TLS: tls, Routes: RouteGroups})
+	}
+
+	return listeners
+}
@@ -7,12 +7,15 @@ require (
 	github.com/NethServer/ns8-core/core/api-server v0.0.0-20230511093202-c2f91171c039
 	github.com/appleboy/gin-jwt/v2 v2.9.1
 	github.com/dgryski/dgoogauth v0.0.0-20190221195224-5a805980a5f3
-	github.com/fatih/structs v1.1.0
 	github.com/gin-contrib/cors v1.4.0
 	github.com/gin-contrib/gzip v0.0.6
-	github.com/gin-contrib/static v0.0.1
 	github.com/gin-gonic/gin v1.9.0
+	github.com/go-asn1-ber/asn1-ber v1.5.4
+	github.com/go-asn1-ber/asn1-ber v1.5.4
+	github.com/go-ldap/ldap/v3 v3.4.4
 	github.com/golang-jwt/jwt v3.2.2+incompatible
-	github.com/nqd/flat v0.2.0
+	github.com/golang-jwt/jwt/v4 v4.4.3
 	github.com/pkg/errors v0.9.1
+	golang.org/x/crypto v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
 )
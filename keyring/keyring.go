@@ -0,0 +1,419 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/logs"
+)
+
+type Key struct {
+	ID         string     `json:"kid" structs:"kid"`
+	Algorithm  string     `json:"alg" structs:"alg"`
+	Secret     string     `json:"secret,omitempty" structs:"secret,omitempty"`
+	PrivateKey string     `json:"private_key,omitempty" structs:"private_key,omitempty"`
+	Created    time.Time  `json:"created" structs:"created"`
+	RetireAt   *time.Time `json:"retire_at,omitempty" structs:"retire_at,omitempty"`
+
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var keys []Key
+var mutex sync.RWMutex
+
+func keyringFile() string {
+	return configuration.Get().KeysDir + "/keyring.json"
+}
+
+func keyID(material []byte) string {
+	// derive key id from key material
+	hash := sha256.Sum256(material)
+	return hex.EncodeToString(hash[:8])
+}
+
+func legacyKeyID() string {
+	// tokens without kid header are signed with static secret
+	return keyID([]byte(configuration.Get().SecretJWT))
+}
+
+func Init() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// read keyring from disk
+	keyringB, err := ioutil.ReadFile(keyringFile())
+	if err == nil {
+		if errJson := json.Unmarshal(keyringB, &keys); errJson != nil {
+			return errors.Wrap(errJson, "keyring file malformed")
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, "keyring file read error")
+	}
+	changed := false
+
+	// seed keyring with static secret on first start
+	if len(keys) == 0 && configuration.Get().SecretJWT != "" {
+		keys = []Key{{
+			ID:        legacyKeyID(),
+			Algorithm: "HS256",
+			Secret:    base64.StdEncoding.EncodeToString([]byte(configuration.Get().SecretJWT)),
+			Created:   time.Now(),
+		}}
+		changed = true
+
+		logs.For("KEYRING").With(logs.Fields{"kid": keys[0].ID}).Info("keyring initialized with static secret")
+	}
+
+	// import private key file as signing key, if not already present
+	if configuration.Get().JWTPrivateKeyFile != "" {
+		key, errFile := loadKeyFile(configuration.Get().JWTPrivateKeyFile)
+		if errFile != nil {
+			return errFile
+		}
+		if key.Algorithm != configuration.Get().JWTAlgorithm {
+			return fmt.Errorf("private key file algorithm %v does not match %v", key.Algorithm, configuration.Get().JWTAlgorithm)
+		}
+		if find(key.ID) < 0 {
+			keys = append([]Key{key}, retire(keys, time.Now())...)
+			changed = true
+
+			logs.For("KEYRING").With(logs.Fields{"kid": key.ID}).Info("private key file imported as new signing key")
+		}
+	}
+
+	// generate signing key when missing or when algorithm changes
+	if len(keys) == 0 || keys[0].Algorithm != configuration.Get().JWTAlgorithm {
+		key, errGen := generate(configuration.Get().JWTAlgorithm)
+		if errGen != nil {
+			return errGen
+		}
+		keys = append([]Key{key}, retire(keys, time.Now())...)
+		changed = true
+
+		logs.For("KEYRING").With(logs.Fields{"kid": key.ID, "alg": key.Algorithm}).Info("keyring initialized with generated key")
+	}
+
+	// parse key material
+	for i := range keys {
+		if errParse := parse(&keys[i]); errParse != nil {
+			return errors.Wrap(errParse, "keyring key "+keys[i].ID+" malformed")
+		}
+	}
+
+	// write keyring to disk
+	if changed {
+		return save()
+	}
+
+	return nil
+}
+
+func save() error {
+	// check if dir exists, otherwise create it
+	if _, errD := os.Stat(configuration.Get().KeysDir); os.IsNotExist(errD) {
+		_ = os.MkdirAll(configuration.Get().KeysDir, 0700)
+	}
+
+	// convert keyring to json
+	keyringB, _ := json.MarshalIndent(keys, "", "  ")
+
+	// write to temp file and move it, to avoid partial keyrings
+	tmpFile := keyringFile() + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, keyringB, 0600); err != nil {
+		return errors.Wrap(err, "keyring file write error")
+	}
+
+	return os.Rename(tmpFile, keyringFile())
+}
+
+func find(kid string) int {
+	for i, key := range keys {
+		if key.ID == kid {
+			return i
+		}
+	}
+	return -1
+}
+
+func retired(key Key, now time.Time) bool {
+	return key.RetireAt != nil && key.RetireAt.Before(now)
+}
+
+func retire(list []Key, now time.Time) []Key {
+	// schedule retirement of signing key and prune retired ones
+	retireAt := now.Add(configuration.Get().KeysRetireAfter)
+	var result []Key
+	for _, key := range list {
+		if retired(key, now) {
+			continue
+		}
+		if key.RetireAt == nil {
+			key.RetireAt = &retireAt
+		}
+		result = append(result, key)
+	}
+	return result
+}
+
+func generate(algorithm string) (Key, error) {
+	key := Key{Algorithm: algorithm, Created: time.Now()}
+
+	switch algorithm {
+	case "HS256":
+		// generate random secret
+		secret := make([]byte, 64)
+		if _, err := rand.Read(secret); err != nil {
+			return Key{}, errors.Wrap(err, "key generation error")
+		}
+		key.ID = keyID(secret)
+		key.Secret = base64.StdEncoding.EncodeToString(secret)
+	case "EdDSA", "RS256":
+		// generate private key
+		var privateKey crypto.Signer
+		var err error
+		if algorithm == "EdDSA" {
+			_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+		} else {
+			privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		}
+		if err != nil {
+			return Key{}, errors.Wrap(err, "key generation error")
+		}
+
+		// encode private key in PEM format
+		privateDer, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return Key{}, errors.Wrap(err, "key encoding error")
+		}
+		publicDer, _ := x509.MarshalPKIXPublicKey(privateKey.Public())
+		key.ID = keyID(publicDer)
+		key.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDer}))
+	default:
+		return Key{}, fmt.Errorf("unsupported signing algorithm: %v", algorithm)
+	}
+
+	return key, parse(&key)
+}
+
+func loadKeyFile(path string) (Key, error) {
+	// read private key file
+	pemB, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Key{}, errors.Wrap(err, "private key file read error")
+	}
+	key := Key{PrivateKey: string(pemB), Created: time.Now()}
+	if err := parse(&key); err != nil {
+		return Key{}, err
+	}
+
+	// derive key id from public key
+	publicDer, _ := x509.MarshalPKIXPublicKey(key.verifyKey)
+	key.ID = keyID(publicDer)
+
+	return key, nil
+}
+
+func parse(key *Key) error {
+	// decode hmac secret
+	if key.PrivateKey == "" {
+		secret, err := base64.StdEncoding.DecodeString(key.Secret)
+		if err != nil {
+			return errors.Wrap(err, "secret malformed")
+		}
+		key.signKey = secret
+		key.verifyKey = secret
+		return nil
+	}
+
+	// decode private key
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return errors.New("private key is not in PEM format")
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return errors.Wrap(err, "private key malformed")
+		}
+	}
+
+	// detect algorithm from key type
+	switch privateKey := privateKey.(type) {
+	case ed25519.PrivateKey:
+		key.Algorithm = "EdDSA"
+		key.signKey = privateKey
+		key.verifyKey = privateKey.Public()
+	case *rsa.PrivateKey:
+		key.Algorithm = "RS256"
+		key.signKey = privateKey
+		key.verifyKey = privateKey.Public()
+	default:
+		return errors.New("private key type not supported, must be Ed25519 or RSA")
+	}
+
+	return nil
+}
+
+func Keys() []Key {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	// return keys without secrets
+	var list []Key
+	for _, key := range keys {
+		if !retired(key, time.Now()) {
+			list = append(list, Key{
+				ID:        key.ID,
+				Algorithm: key.Algorithm,
+				Created:   key.Created,
+				RetireAt:  key.RetireAt,
+			})
+		}
+	}
+
+	return list
+}
+
+func PublicKeys() JWKS {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	// export public part of asymmetric keys
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range keys {
+		if retired(key, time.Now()) {
+			continue
+		}
+		switch verifyKey := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				KeyType:   "OKP",
+				Use:       "sig",
+				ID:        key.ID,
+				Algorithm: key.Algorithm,
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(verifyKey),
+			})
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				KeyType:   "RSA",
+				Use:       "sig",
+				ID:        key.ID,
+				Algorithm: key.Algorithm,
+				N:         base64.RawURLEncoding.EncodeToString(verifyKey.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(verifyKey.E)).Bytes()),
+			})
+		}
+	}
+
+	return jwks
+}
+
+func Rotate() (Key, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// generate new signing key
+	key, err := generate(configuration.Get().JWTAlgorithm)
+	if err != nil {
+		return Key{}, err
+	}
+
+	// save new keyring
+	oldKeys := keys
+	keys = append([]Key{key}, retire(keys, time.Now())...)
+	if err := save(); err != nil {
+		keys = oldKeys
+		return Key{}, err
+	}
+
+	logs.For("KEYRING").With(logs.Fields{"kid": key.ID}).Info("keyring rotated")
+
+	return Key{ID: key.ID, Algorithm: key.Algorithm, Created: key.Created}, nil
+}
+
+func Lookup(kid string, alg string) (interface{}, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	// tokens issued before the keyring have no kid
+	if kid == "" {
+		kid = legacyKeyID()
+	}
+
+	// search verification key
+	for _, key := range keys {
+		if key.ID == kid && !retired(key, time.Now()) {
+			if key.Algorithm != alg {
+				return nil, fmt.Errorf("unexpected signing method: %v", alg)
+			}
+			return key.verifyKey, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown signing key: %v", kid)
+}
+
+func KeyFunc(token *jwt.Token) (interface{}, error) {
+	// read key id and algorithm from header
+	kid, _ := token.Header["kid"].(string)
+	alg, _ := token.Header["alg"].(string)
+
+	return Lookup(kid, alg)
+}
+
+func Sign(claims map[string]interface{}) (string, error) {
+	mutex.RLock()
+	active := keys[0]
+	mutex.RUnlock()
+
+	// create token with key id header
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(active.Algorithm), jwt.MapClaims(claims))
+	token.Header["kid"] = active.ID
+
+	return token.SignedString(active.signKey)
+}
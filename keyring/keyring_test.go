@@ -0,0 +1,282 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package keyring
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/NethServer/ns-api-server/configuration"
+)
+
+func setup(t *testing.T, algorithm string) string {
+	t.Helper()
+	dir := t.TempDir()
+	configuration.Set(configuration.Configuration{
+		SecretJWT:       "static-secret",
+		JWTAlgorithm:    algorithm,
+		KeysDir:         dir,
+		KeysRetireAfter: time.Hour,
+	})
+	keys = nil
+	t.Cleanup(func() {
+		configuration.Set(configuration.Configuration{})
+		keys = nil
+	})
+	return dir
+}
+
+func verify(token string) (*jwt.Token, error) {
+	return jwt.Parse(token, KeyFunc)
+}
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		keys      int
+		jwks      int
+	}{
+		// static secret is the signing key
+		{algorithm: "HS256", keys: 1, jwks: 0},
+		// static secret is kept to verify old tokens
+		{algorithm: "EdDSA", keys: 2, jwks: 1},
+		{algorithm: "RS256", keys: 2, jwks: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.algorithm, func(t *testing.T) {
+			dir := setup(t, test.algorithm)
+			if err := Init(); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(Keys()) != test.keys {
+				t.Errorf("keyring has %d keys, expected %d", len(Keys()), test.keys)
+			}
+			if Keys()[0].Algorithm != test.algorithm {
+				t.Errorf("signing key algorithm is %v", Keys()[0].Algorithm)
+			}
+			if len(PublicKeys().Keys) != test.jwks {
+				t.Errorf("jwks has %d keys, expected %d", len(PublicKeys().Keys), test.jwks)
+			}
+			for _, key := range Keys() {
+				if key.Secret != "" || key.PrivateKey != "" {
+					t.Errorf("key %v is listed with its secret", key.ID)
+				}
+			}
+
+			// keyring is read again on restart, keys don't change
+			signingKey := Keys()[0].ID
+			keys = nil
+			if err := Init(); err != nil {
+				t.Fatal(err)
+			}
+			if Keys()[0].ID != signingKey {
+				t.Errorf("signing key changed on restart")
+			}
+			if _, err := ioutil.ReadFile(filepath.Join(dir, "keyring.json")); err != nil {
+				t.Errorf("keyring not saved: %v", err)
+			}
+		})
+	}
+}
+
+func TestInitMalformed(t *testing.T) {
+	dir := setup(t, "HS256")
+	if err := ioutil.WriteFile(filepath.Join(dir, "keyring.json"), []byte(`[{"kid": "a", "alg": "HS256", "secret": "%%%"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Init(); err == nil {
+		t.Error("malformed key accepted")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "keyring.json"), []byte(`{`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys = nil
+	if err := Init(); err == nil {
+		t.Error("malformed keyring accepted")
+	}
+}
+
+func TestSignAndLookup(t *testing.T) {
+	for _, algorithm := range []string{"HS256", "EdDSA", "RS256"} {
+		t.Run(algorithm, func(t *testing.T) {
+			setup(t, algorithm)
+			if err := Init(); err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := Sign(map[string]interface{}{"id": "admin"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := verify(token)
+			if err != nil || !parsed.Valid {
+				t.Fatalf("signed token not valid: %v", err)
+			}
+			if parsed.Header["kid"] != Keys()[0].ID || parsed.Method.Alg() != algorithm {
+				t.Errorf("unexpected header %v", parsed.Header)
+			}
+		})
+	}
+}
+
+func TestLookupRejects(t *testing.T) {
+	setup(t, "EdDSA")
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": "admin"})
+
+	tests := []struct {
+		name  string
+		token func() string
+		valid bool
+	}{
+		{
+			name: "legacy token without kid",
+			token: func() string {
+				token, _ := legacy.SignedString([]byte("static-secret"))
+				return token
+			},
+			valid: true,
+		},
+		{
+			name: "legacy token signed with another secret",
+			token: func() string {
+				token, _ := legacy.SignedString([]byte("other-secret"))
+				return token
+			},
+		},
+		{
+			name: "unknown key id",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": "admin"})
+				token.Header["kid"] = "0000000000000000"
+				signed, _ := token.SignedString([]byte("static-secret"))
+				return signed
+			},
+		},
+		{
+			name: "algorithm confusion with public key as hmac secret",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": "admin"})
+				token.Header["kid"] = Keys()[0].ID
+				signed, _ := token.SignedString([]byte(PublicKeys().Keys[0].X))
+				return signed
+			},
+		},
+		{
+			name: "unsigned token",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"id": "admin"})
+				token.Header["kid"] = Keys()[0].ID
+				signed, _ := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+				return signed
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := verify(test.token())
+			if (err == nil) != test.valid {
+				t.Errorf("token valid is %v, expected %v: %v", err == nil, test.valid, err)
+			}
+		})
+	}
+}
+
+func TestRotate(t *testing.T) {
+	setup(t, "HS256")
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := Sign(map[string]interface{}{"id": "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := Sign(map[string]interface{}{"id": "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// new tokens use the new key, old ones are valid until retirement
+	parsed, err := verify(after)
+	if err != nil || parsed.Header["kid"] != rotated.ID {
+		t.Errorf("token not signed with rotated key: %v", err)
+	}
+	if _, err := verify(before); err != nil {
+		t.Errorf("token of previous key rejected before retirement: %v", err)
+	}
+	if len(Keys()) != 2 || Keys()[1].RetireAt == nil {
+		t.Fatalf("previous key not scheduled for retirement: %+v", Keys())
+	}
+
+	// retired keys are not listed and don't verify tokens
+	past := time.Now().Add(-time.Minute)
+	keys[1].RetireAt = &past
+	if _, err := verify(before); err == nil {
+		t.Error("token of retired key accepted")
+	}
+	if len(Keys()) != 1 {
+		t.Errorf("retired key listed: %+v", Keys())
+	}
+
+	// retired keys are pruned on next rotation
+	if _, err := Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("retired key not pruned, keyring has %d keys", len(keys))
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	dir := setup(t, "EdDSA")
+
+	// key file of another keyring is imported as signing key
+	generated, err := generate("EdDSA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(file, []byte(generated.PrivateKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := *configuration.Get()
+	config.JWTPrivateKeyFile = file
+	configuration.Set(config)
+
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	if Keys()[0].ID != generated.ID {
+		t.Errorf("signing key is %v, expected imported %v", Keys()[0].ID, generated.ID)
+	}
+
+	// algorithm of key file must match configuration
+	config.JWTAlgorithm = "RS256"
+	configuration.Set(config)
+	keys = nil
+	if err := Init(); err == nil {
+		t.Error("key file with another algorithm accepted")
+	}
+}
@@ -10,20 +10,252 @@
 package logs
 
 import (
-	"log/syslog"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
 )
 
-var Logs *syslog.Writer
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+	LevelCritical
+)
+
+var levelNames = []string{"debug", "info", "warning", "error", "critical"}
+var levelTags = []string{"DEBUG", "INFO", "WARNING", "ERR", "CRITICAL"}
+
+func (level Level) String() string {
+	return levelNames[level]
+}
+
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if levelName == strings.ToLower(name) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %s", name)
+}
+
+type Fields map[string]interface{}
+
+type Entry struct {
+	Time      time.Time
+	Level     Level
+	Subsystem string
+	Message   string
+	Fields    Fields
+}
+
+type Sink interface {
+	Write(entry Entry) error
+	Close() error
+}
+
+type Logger struct {
+	subsystem string
+	fields    Fields
+}
+
+// gin context key of request id
+const RequestIDKey = "REQUEST_ID"
+
+var sinks []Sink
+var redactor func(string) string
+var level = LevelInfo
+var subsystemLevels = map[string]Level{}
+var mutex sync.RWMutex
 
 func Init() {
-	// init syslog writer
-	sysLog, err := syslog.New(syslog.LOG_WARNING|syslog.LOG_DAEMON, "ns_api_server")
+	// log to syslog, or to stderr when syslog is not available
+	sink, err := NewSyslogSink()
+	if err != nil {
+		sinks = []Sink{NewStreamSink(os.Stderr)}
+		For("LOGS").With(Fields{"error": err.Error()}).Warning("syslog not available, logging to stderr")
+		return
+	}
+	sinks = []Sink{sink}
+}
+
+func Configure(newSinks []Sink, defaultLevel string, levels []string) error {
+	// apply levels before switching sinks, to validate them
+	if err := SetLevels(defaultLevel, levels); err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	oldSinks := sinks
+	sinks = newSinks
+	mutex.Unlock()
+
+	for _, sink := range oldSinks {
+		sink.Close()
+	}
+
+	return nil
+}
+
+func ParseLevels(defaultLevel string, levels []string) (Level, map[string]Level, error) {
+	// parse default level
+	parsedLevel, err := ParseLevel(defaultLevel)
+	if err != nil {
+		return LevelInfo, nil, err
+	}
+
+	// parse subsystem levels, in <subsystem>=<level> format
+	parsedSubsystemLevels := map[string]Level{}
+	for _, value := range levels {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return LevelInfo, nil, fmt.Errorf("invalid subsystem log level %s", value)
+		}
+		subsystemLevel, err := ParseLevel(parts[1])
+		if err != nil {
+			return LevelInfo, nil, err
+		}
+		parsedSubsystemLevels[strings.ToUpper(parts[0])] = subsystemLevel
+	}
+
+	return parsedLevel, parsedSubsystemLevels, nil
+}
 
-	// check error on init
+func SetLevels(defaultLevel string, levels []string) error {
+	newLevel, newSubsystemLevels, err := ParseLevels(defaultLevel, levels)
 	if err != nil {
-		sysLog.Crit("[CRITICAL][LOGS] Failed to init syslog logs: " + err.Error())
+		return err
+	}
+
+	mutex.Lock()
+	level = newLevel
+	subsystemLevels = newSubsystemLevels
+	mutex.Unlock()
+
+	return nil
+}
+
+func Close() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// flush and close all sinks
+	for _, sink := range sinks {
+		sink.Close()
+	}
+	sinks = nil
+}
+
+func SetRedactor(redact func(string) string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	redactor = redact
+}
+
+func redactEntry(entry Entry) Entry {
+	// redact message and text fields, before any sink
+	entry.Message = redactor(entry.Message)
+	fields := Fields{}
+	for key, value := range entry.Fields {
+		switch value := value.(type) {
+		case string:
+			fields[key] = redactor(value)
+		case error:
+			fields[key] = redactor(value.Error())
+		default:
+			fields[key] = value
+		}
+	}
+	entry.Fields = fields
+
+	return entry
+}
+
+func For(subsystem string) *Logger {
+	return &Logger{subsystem: strings.ToUpper(subsystem)}
+}
+
+func ForRequest(c *gin.Context, subsystem string) *Logger {
+	// add request id, if set by middleware
+	if id := c.GetString(RequestIDKey); id != "" {
+		return For(subsystem).With(Fields{"request_id": id})
+	}
+	return For(subsystem)
+}
+
+func (logger *Logger) With(fields Fields) *Logger {
+	// copy fields, loggers can be shared
+	merged := Fields{}
+	for key, value := range logger.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return &Logger{subsystem: logger.subsystem, fields: merged}
+}
+
+func (logger *Logger) Enabled(entryLevel Level) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if subsystemLevel, ok := subsystemLevels[logger.subsystem]; ok {
+		return entryLevel >= subsystemLevel
+	}
+	return entryLevel >= level
+}
+
+func (logger *Logger) log(entryLevel Level, message string) {
+	if !logger.Enabled(entryLevel) {
+		return
+	}
+
+	entry := Entry{
+		Time:      time.Now(),
+		Level:     entryLevel,
+		Subsystem: logger.subsystem,
+		Message:   message,
+		Fields:    logger.fields,
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if redactor != nil {
+		entry = redactEntry(entry)
+	}
+
+	// sink errors can not be logged, write them on stderr
+	for _, sink := range sinks {
+		if err := sink.Write(entry); err != nil {
+			fmt.Fprintln(os.Stderr, "log write error: "+err.Error())
+		}
 	}
+}
+
+func (logger *Logger) Debug(message string) {
+	logger.log(LevelDebug, message)
+}
+
+func (logger *Logger) Info(message string) {
+	logger.log(LevelInfo, message)
+}
+
+func (logger *Logger) Warning(message string) {
+	logger.log(LevelWarning, message)
+}
+
+func (logger *Logger) Err(message string) {
+	logger.log(LevelError, message)
+}
 
-	// assign writer to Logs var
-	Logs = sysLog
+func (logger *Logger) Crit(message string) {
+	logger.log(LevelCritical, message)
 }
@@ -0,0 +1,235 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func Text(entry Entry) string {
+	// format entry as "[LEVEL][SUBSYSTEM] message key=value ..."
+	var text strings.Builder
+	text.WriteString("[" + levelTags[entry.Level] + "][" + entry.Subsystem + "] " + entry.Message)
+
+	// fields are sorted, to keep lines comparable
+	keys := make([]string, 0, len(entry.Fields))
+	for key := range entry.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := fmt.Sprint(entry.Fields[key])
+		if value == "" || strings.ContainsAny(value, " \"=") {
+			value = strconv.Quote(value)
+		}
+		text.WriteString(" " + key + "=" + value)
+	}
+
+	return text.String()
+}
+
+func JSON(entry Entry) []byte {
+	// fields are written at top level, next to standard keys
+	object := map[string]interface{}{}
+	for key, value := range entry.Fields {
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		object[key] = value
+	}
+	object["time"] = entry.Time.Format(time.RFC3339Nano)
+	object["level"] = entry.Level.String()
+	object["subsystem"] = strings.ToLower(entry.Subsystem)
+	object["msg"] = entry.Message
+
+	line, err := json.Marshal(object)
+	if err != nil {
+		line, _ = json.Marshal(map[string]interface{}{"time": object["time"], "level": object["level"], "subsystem": object["subsystem"], "msg": entry.Message})
+	}
+
+	return append(line, '\n')
+}
+
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+func NewSyslogSink() (*SyslogSink, error) {
+	writer, err := syslog.New(syslog.LOG_WARNING|syslog.LOG_DAEMON, "ns_api_server")
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{writer: writer}, nil
+}
+
+func (sink *SyslogSink) Write(entry Entry) error {
+	// map level to syslog severity
+	text := Text(entry)
+	switch entry.Level {
+	case LevelDebug:
+		return sink.writer.Debug(text)
+	case LevelInfo:
+		return sink.writer.Info(text)
+	case LevelWarning:
+		return sink.writer.Warning(text)
+	case LevelError:
+		return sink.writer.Err(text)
+	default:
+		return sink.writer.Crit(text)
+	}
+}
+
+func (sink *SyslogSink) Close() error {
+	return sink.writer.Close()
+}
+
+type StreamSink struct {
+	writer io.Writer
+	mutex  sync.Mutex
+}
+
+func NewStreamSink(writer io.Writer) *StreamSink {
+	return &StreamSink{writer: writer}
+}
+
+func (sink *StreamSink) Write(entry Entry) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	_, err := sink.writer.Write(JSON(entry))
+	return err
+}
+
+func (sink *StreamSink) Close() error {
+	return nil
+}
+
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	mutex      sync.Mutex
+}
+
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	sink := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (sink *FileSink) open() error {
+	file, err := os.OpenFile(sink.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	sink.file = file
+	sink.size = info.Size()
+	return nil
+}
+
+func (sink *FileSink) rotate() error {
+	sink.file.Close()
+	sink.file = nil
+
+	// shift backups: file.1 -> file.2, ..., oldest one is removed
+	for i := sink.maxBackups; i > 0; i-- {
+		from := sink.path + "." + strconv.Itoa(i-1)
+		if i == 1 {
+			from = sink.path
+		}
+		if i == sink.maxBackups {
+			os.Remove(sink.path + "." + strconv.Itoa(i))
+		}
+		os.Rename(from, sink.path+"."+strconv.Itoa(i))
+	}
+	if sink.maxBackups == 0 {
+		os.Remove(sink.path)
+	}
+
+	return sink.open()
+}
+
+func (sink *FileSink) Write(entry Entry) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	if sink.file == nil {
+		return nil
+	}
+
+	// rotate file when it exceeds max size
+	line := JSON(entry)
+	if sink.maxSize > 0 && sink.size+int64(len(line)) > sink.maxSize && sink.size > 0 {
+		if err := sink.rotate(); err != nil {
+			return err
+		}
+	}
+
+	written, err := sink.file.Write(line)
+	sink.size += int64(written)
+	return err
+}
+
+func (sink *FileSink) Close() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	if sink.file == nil {
+		return nil
+	}
+	err := sink.file.Close()
+	sink.file = nil
+	return err
+}
+
+func Open(names []string, path string, maxSize int, maxBackups int) ([]Sink, error) {
+	var opened []Sink
+	for _, name := range names {
+		var sink Sink
+		var err error
+		switch name {
+		case "syslog":
+			sink, err = NewSyslogSink()
+		case "stderr":
+			sink = NewStreamSink(os.Stderr)
+		case "file":
+			sink, err = NewFileSink(path, int64(maxSize)*1024*1024, maxBackups)
+		default:
+			err = fmt.Errorf("unknown log sink %s", name)
+		}
+		if err != nil {
+			for _, sink := range opened {
+				sink.Close()
+			}
+			return nil, err
+		}
+		opened = append(opened, sink)
+	}
+
+	return opened, nil
+}
@@ -10,20 +10,32 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"strconv"
 
-	"github.com/fatih/structs"
-	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/gzip"
-	"github.com/gin-contrib/static"
 	"github.com/gin-gonic/gin"
 
+	"github.com/NethServer/ns-api-server/apikeys"
+	"github.com/NethServer/ns-api-server/audit"
+	"github.com/NethServer/ns-api-server/authenticator"
 	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/keyring"
 	"github.com/NethServer/ns-api-server/logs"
 	"github.com/NethServer/ns-api-server/methods"
+	"github.com/NethServer/ns-api-server/metrics"
 	"github.com/NethServer/ns-api-server/middleware"
+	"github.com/NethServer/ns-api-server/oidc"
+	"github.com/NethServer/ns-api-server/openapi"
+	"github.com/NethServer/ns-api-server/ratelimit"
+	"github.com/NethServer/ns-api-server/redact"
 	"github.com/NethServer/ns-api-server/response"
+	"github.com/NethServer/ns-api-server/server"
+	"github.com/NethServer/ns-api-server/ui"
 )
 
 // @title NethSecurity Controller API Server
@@ -41,11 +53,97 @@ import (
 // @BasePath /api
 
 func main() {
-	// init logs with syslog
+	// read command line
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "configuration file, in JSON, YAML or UCI format (default "+configuration.DefaultFile+" if exists)")
+	checkConfig := flag.Bool("check-config", false, "validate configuration and exit")
+	verifyAudit := flag.String("verify-audit", "", "verify hash chain of given audit file and exit")
+	flag.Parse()
+
+	// verify audit file only
+	if *verifyAudit != "" {
+		count, err := audit.Verify(*verifyAudit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "audit file is not valid after "+strconv.FormatInt(count, 10)+" entries: "+err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("audit file is valid, " + strconv.FormatInt(count, 10) + " entries verified")
+		os.Exit(0)
+	}
+
+	// validate configuration only
+	if *checkConfig {
+		if _, err := configuration.Load(*configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("configuration is valid")
+		os.Exit(0)
+	}
+
+	// init logs with syslog, until configuration is read
 	logs.Init()
 
 	// init configuration
-	configuration.Init()
+	configuration.Init(*configFile)
+
+	// apply logs configuration
+	sinks, err := logs.Open(configuration.Get().LogSinks, configuration.Get().LogFile, configuration.Get().LogFileMaxSize, configuration.Get().LogFileMaxBackups)
+	if err == nil {
+		err = logs.Configure(sinks, configuration.Get().LogLevel, configuration.Get().LogLevels)
+	}
+	if err != nil {
+		logs.For("LOGS").With(logs.Fields{"error": err.Error()}).Crit("Failed to init logs")
+		os.Exit(1)
+	}
+
+	// redact sensitive data in logs
+	if err := redact.Init(); err != nil {
+		logs.For("ENV").With(logs.Fields{"error": err.Error()}).Crit("Failed to init redaction")
+		os.Exit(1)
+	}
+	logs.SetRedactor(redact.Text)
+
+	// init rate limits
+	if err := ratelimit.Init(); err != nil {
+		logs.For("ENV").With(logs.Fields{"error": err.Error()}).Crit("Failed to init rate limits")
+		os.Exit(1)
+	}
+
+	// log levels, redaction rules and rate limits can be changed on reload
+	configuration.OnReload(func() {
+		_ = logs.SetLevels(configuration.Get().LogLevel, configuration.Get().LogLevels)
+		_ = redact.Init()
+		_ = ratelimit.Init()
+	})
+
+	// init authentication providers
+	if err := authenticator.Init(); err != nil {
+		logs.For("AUTH").With(logs.Fields{"error": err.Error()}).Crit("Failed to init authentication providers")
+		os.Exit(1)
+	}
+
+	// init jwt keyring
+	if err := keyring.Init(); err != nil {
+		logs.For("KEYRING").With(logs.Fields{"error": err.Error()}).Crit("Failed to init keyring")
+		os.Exit(1)
+	}
+
+	// tokens in urls end up in proxy logs and browser history
+	if configuration.Get().JWTQueryLookup {
+		logs.For("JWT").Warning("tokens in jwt query parameter are accepted, use tickets instead")
+	}
+
+	// init api keys
+	if err := apikeys.Init(); err != nil {
+		logs.For("APIKEY").With(logs.Fields{"error": err.Error()}).Crit("Failed to init api keys")
+		os.Exit(1)
+	}
+
+	// init audit trail
+	if err := audit.Init(); err != nil {
+		logs.For("AUDIT").With(logs.Fields{"error": err.Error()}).Crit("Failed to init audit")
+		os.Exit(1)
+	}
 
 	// disable log to stdout when running in release mode
 	if gin.Mode() == gin.ReleaseMode {
@@ -55,55 +153,126 @@ func main() {
 	// init routers
 	router := gin.Default()
 
-	// add default compression
-	router.Use(gzip.Gzip(gzip.DefaultCompression))
+	// trust forwarded client address only from configured proxies
+	if err := router.SetTrustedProxies(configuration.Get().TrustedProxies); err != nil {
+		logs.For("ENV").With(logs.Fields{"error": err.Error()}).Crit("TRUSTED_PROXIES variable is invalid")
+		os.Exit(1)
+	}
+
+	// log requests and collect metrics
+	router.Use(middleware.AccessLog(), middleware.Metrics())
+
+	// add request id to logs, responses and ubus calls
+	router.Use(middleware.RequestID())
+
+	// cross origin policy and security headers
+	router.Use(middleware.CORS(), middleware.SecurityHeaders())
 
-	// cors configuration only in debug mode GIN_MODE=debug (default)
-	if gin.Mode() == gin.DebugMode {
-		// gin gonic cors conf
-		corsConf := cors.DefaultConfig()
-		corsConf.AllowHeaders = []string{"Authorization", "Content-Type", "Accept"}
-		corsConf.AllowAllOrigins = true
-		router.Use(cors.New(corsConf))
+	// prometheus metrics
+	metrics.NewGaugeFunc("ns_api_server_sessions_active", "Valid login sessions not yet expired.", func() float64 {
+		return float64(methods.ActiveSessions())
+	})
+	metrics.NewGaugeFunc("ns_api_server_rate_limit_clients", "Clients tracked by rate limiter.", func() float64 {
+		return float64(ratelimit.Clients())
+	})
+	// compress dynamic responses, web ui assets are precompressed
+	compress := gzip.Gzip(gzip.DefaultCompression)
+	router.GET("/metrics", compress, methods.GetMetrics)
+
+	// serve web ui, with history mode fallback to its index
+	router.Use(ui.Serve())
+
+	// define api groups, unversioned api is an alias of the first version
+	apiRoutes(router.Group("/api/v1", compress))
+	apiRoutes(router.Group("/api", compress, middleware.LegacyAPI("/api", "/api/v1")))
+
+	// describe all routes in api documentation
+	openapi.Register(router.Routes())
+
+	// handle missing endpoint
+	router.NoRoute(func(c *gin.Context) {
+		response.Error(c, http.StatusNotFound, response.ErrNotFound, "API not found", nil)
+	})
+
+	// run server, until shutdown
+	if err := server.Run(router); err != nil {
+		logs.For("SERVER").With(logs.Fields{"error": err.Error()}).Crit("Failed to run server")
+		os.Exit(1)
 	}
 
-	// define static file endpoint
-	router.Use(static.Serve("/", static.LocalFile(configuration.Config.StaticDir, false)))
+	// save api keys usage counters
+	if err := apikeys.Flush(); err != nil {
+		logs.For("APIKEY").With(logs.Fields{"error": err.Error()}).Err("Failed to save api keys")
+	}
+
+	// send pending audit entries
+	audit.Close()
+
+	// flush pending logs
+	logs.For("SERVER").Info("shutdown completed")
+	logs.Close()
+}
 
-	// define api group
-	api := router.Group("/api")
+func apiRoutes(api *gin.RouterGroup) {
+	// limit public APIs by client address
+	public := api.Group("", middleware.RateLimit())
 
 	// define login and logout endpoint
-	api.POST("/login", middleware.InstanceJWT().LoginHandler)
-	api.POST("/logout", middleware.InstanceJWT().LogoutHandler)
+	public.POST("/login", middleware.LoginHandler)
+	public.POST("/logout", middleware.CSRF(), middleware.InstanceJWT().LogoutHandler)
 
 	// 2FA APIs
-	api.POST("/2fa/otp-verify", methods.OTPVerify)
+	public.POST("/2fa/otp-verify", methods.OTPVerify)
 
-	// define JWT middleware
-	api.Use(middleware.InstanceJWT().MiddlewareFunc())
+	// single sign-on APIs
+	if oidc.Enabled() {
+		public.GET("/oidc/login", middleware.OIDCLogin)
+		public.GET("/oidc/callback", middleware.OIDCCallback)
+	}
+
+	// public keys for token verification
+	public.GET("/.well-known/jwks.json", methods.GetJWKS)
+
+	// health APIs
+	public.GET("/health", methods.GetHealth)
+	public.GET("/ready", methods.GetReady)
+
+	// api documentation
+	public.GET("/openapi.json", methods.GetOpenAPI)
+	if configuration.Get().OpenAPIExplorer {
+		public.GET("/docs", methods.GetExplorer)
+	}
+
+	// define audit, CSRF, tickets, JWT and api keys middleware, then limit by user or api key
+	private := api.Group("", middleware.Audit(), middleware.CSRF(), middleware.Ticket(), middleware.Authenticate(), middleware.RateLimit())
 	{
 		// refresh handler
-		api.GET("/refresh", middleware.InstanceJWT().RefreshHandler)
+		private.GET("/refresh", middleware.RefreshHandler)
+
+		// download tickets
+		private.POST("/tickets", methods.CreateTicket)
 
 		// ubus wrapper
-		api.POST("/ubus/call", methods.UBusCallAction)
+		private.POST("/ubus/call", methods.UBusCallAction)
 
 		// 2FA APIs
-		api.GET("/2fa", methods.Get2FAStatus)
-		api.DELETE("/2fa", methods.Del2FAStatus)
-		api.GET("/2fa/qr-code", methods.QRCode)
-	}
+		private.GET("/2fa", methods.Get2FAStatus)
+		private.DELETE("/2fa", methods.Del2FAStatus)
+		private.GET("/2fa/qr-code", methods.QRCode)
 
-	// handle missing endpoint
-	router.NoRoute(func(c *gin.Context) {
-		c.JSON(http.StatusNotFound, structs.Map(response.StatusNotFound{
-			Code:    404,
-			Message: "API not found",
-			Data:    nil,
-		}))
-	})
+		// keyring APIs
+		private.GET("/keys", methods.GetKeys)
+		private.POST("/keys/rotate", methods.RotateKeys)
 
-	// run server
-	router.Run(configuration.Config.ListenAddress)
+		// api keys APIs
+		private.GET("/api-keys", methods.GetAPIKeys)
+		private.POST("/api-keys", methods.CreateAPIKey)
+		private.DELETE("/api-keys/:id", methods.DeleteAPIKey)
+
+		// audit APIs
+		private.GET("/audit", methods.GetAudit)
+
+		// diagnostics APIs
+		private.GET("/diagnostics", methods.GetDiagnostics)
+	}
 }
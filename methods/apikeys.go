@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package methods
+
+import (
+	"net/http"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/NethServer/ns-api-server/apikeys"
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/models"
+	"github.com/NethServer/ns-api-server/response"
+)
+
+func GetAPIKeys(c *gin.Context) {
+	// return api keys without hashes
+	response.OK(c, "api keys", apikeys.List())
+}
+
+func CreateAPIKey(c *gin.Context) {
+	// get payload
+	var jsonAPIKey models.APIKeyJSON
+	if err := c.ShouldBindBodyWith(&jsonAPIKey, binding.JSON); err != nil {
+		response.Error(c, http.StatusBadRequest, response.ErrBadRequest, "request fields malformed", err.Error())
+		return
+	}
+
+	// get claims from token
+	claims := jwt.ExtractClaims(c)
+
+	// create api key
+	key, secret, err := apikeys.Create(jsonAPIKey, claims["id"].(string))
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, response.ErrValidationFailed, "api key creation failed", err.Error())
+		return
+	}
+
+	// write logs
+	logs.ForRequest(c, "APIKEY").With(logs.Fields{"id": key.ID, "user": claims["id"], "ip": c.ClientIP()}).Info("api key created")
+
+	// response, the key is shown only once
+	response.Created(c, "api key created", gin.H{"key": secret, "api_key": key})
+}
+
+func DeleteAPIKey(c *gin.Context) {
+	// get claims from token
+	claims := jwt.ExtractClaims(c)
+
+	// remove api key
+	if !apikeys.Delete(c.Param("id")) {
+		response.Error(c, http.StatusNotFound, response.ErrAPIKeyNotFound, "api key not found", nil)
+		return
+	}
+
+	// write logs
+	logs.ForRequest(c, "APIKEY").With(logs.Fields{"id": c.Param("id"), "user": claims["id"], "ip": c.ClientIP()}).Info("api key deleted")
+
+	// response
+	response.OK(c, "api key deleted", nil)
+}
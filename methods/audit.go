@@ -0,0 +1,84 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package methods
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/audit"
+	"github.com/NethServer/ns-api-server/models"
+	"github.com/NethServer/ns-api-server/response"
+)
+
+// entries returned by a page, if limit is not given
+var auditPageSize = 100
+
+func GetAudit(c *gin.Context) {
+	// parse filters
+	var query models.AuditQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		response.Error(c, http.StatusBadRequest, response.ErrBadRequest, "request fields malformed", err.Error())
+		return
+	}
+
+	// export all matching entries, paginate otherwise
+	if query.Limit == 0 && query.Export == "" {
+		query.Limit = auditPageSize
+	}
+
+	// read audit entries
+	entries, next, err := audit.Query(query)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, response.ErrAuditFailed, "audit read failed", err.Error())
+		return
+	}
+
+	// export entries as file
+	filename := "audit-" + time.Now().Format("20060102150405")
+	switch query.Export {
+	case "csv":
+		c.Header("Content-Disposition", "attachment; filename="+filename+".csv")
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		writer := csv.NewWriter(c.Writer)
+		writer.Write([]string{"seq", "time", "user", "role", "auth", "ip", "request_id", "action", "object", "method", "payload", "status", "result", "hash"})
+		for _, entry := range entries {
+			writer.Write([]string{
+				strconv.FormatInt(entry.Seq, 10),
+				entry.Time.Format(time.RFC3339Nano),
+				entry.User,
+				entry.Role,
+				entry.Auth,
+				entry.IP,
+				entry.Request,
+				entry.Action,
+				entry.Object,
+				entry.Method,
+				string(entry.Payload),
+				strconv.Itoa(entry.Status),
+				entry.Result,
+				entry.Hash,
+			})
+		}
+		writer.Flush()
+		return
+	case "json":
+		c.Header("Content-Disposition", "attachment; filename="+filename+".json")
+		c.JSON(http.StatusOK, entries)
+		return
+	}
+
+	// return entries, newest first
+	response.OK(c, "audit entries", gin.H{"entries": entries, "next_cursor": next})
+}
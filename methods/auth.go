@@ -13,68 +13,51 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base32"
-	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"strings"
+	"time"
 
 	jwt "github.com/appleboy/gin-jwt/v2"
 	"github.com/dgryski/dgoogauth"
-	"github.com/fatih/structs"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	jwtl "github.com/golang-jwt/jwt"
 
+	"github.com/NethServer/ns-api-server/authenticator"
 	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/keyring"
 	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/metrics"
 	"github.com/NethServer/ns-api-server/models"
 	"github.com/NethServer/ns-api-server/response"
 )
 
 var ctx = context.Background()
 
-func CheckAuthentication(username string, password string) error {
-	// define login object
-	login := models.UserLogin{
-		Username: username,
-		Password: password,
-		Timeout:  1,
-	}
-	jsonLogin, _ := json.Marshal(login)
-
-	// execute login command on ubus
-	_, err := exec.Command("/bin/ubus", "call", "session", "login", string(jsonLogin)).Output()
-
-	if err != nil {
-		return err
-	}
-
-	return nil
+func CheckAuthentication(username string, password string) (string, error) {
+	// check credentials with configured providers, returning user role
+	return authenticator.Authenticate(username, password)
 }
 
 func OTPVerify(c *gin.Context) {
 	// get payload
 	var jsonOTP models.OTPJson
 	if err := c.ShouldBindBodyWith(&jsonOTP, binding.JSON); err != nil {
-		c.JSON(http.StatusBadRequest, structs.Map(response.StatusBadRequest{
-			Code:    400,
-			Message: "request fields malformed",
-			Data:    err.Error(),
-		}))
+		response.Error(c, http.StatusBadRequest, response.ErrBadRequest, "request fields malformed", err.Error())
 		return
 	}
 
+	// with session cookie, token of first login step is in the cookie
+	if jsonOTP.Token == "" && configuration.Get().SessionCookie {
+		jsonOTP.Token, _ = c.Cookie(configuration.Get().SessionCookieName)
+	}
+
 	// verify JWT
 	if !ValidateAuth(jsonOTP.Token, false) {
-		c.JSON(http.StatusBadRequest, structs.Map(response.StatusBadRequest{
-			Code:    400,
-			Message: "JWT token invalid",
-			Data:    "",
-		}))
+		response.Error(c, http.StatusBadRequest, response.ErrTokenInvalid, "JWT token invalid", nil)
 		return
 	}
 
@@ -83,11 +66,7 @@ func OTPVerify(c *gin.Context) {
 
 	// check secret
 	if len(secret) == 0 {
-		c.JSON(http.StatusNotFound, structs.Map(response.StatusNotFound{
-			Code:    404,
-			Message: "user secret not found",
-			Data:    "",
-		}))
+		response.Error(c, http.StatusNotFound, response.ErrOTPNotFound, "user secret not found", nil)
 		return
 	}
 
@@ -101,22 +80,19 @@ func OTPVerify(c *gin.Context) {
 	// verifiy OTP
 	result, err := otpc.Authenticate(jsonOTP.OTP)
 	if err != nil || !result {
-		c.JSON(http.StatusBadRequest, structs.Map(response.StatusBadRequest{
-			Code:    400,
-			Message: "OTP token invalid",
-			Data:    "",
-		}))
+		metrics.OTPFailures.Inc()
+		response.Error(c, http.StatusBadRequest, response.ErrOTPInvalid, "OTP token invalid", nil)
 		return
 	}
 
 	// check if 2FA was disabled
-	status, err := os.ReadFile(configuration.Config.SecretsDir + "/" + jsonOTP.Username + "/status")
+	status, err := os.ReadFile(configuration.Get().SecretsDir + "/" + jsonOTP.Username + "/status")
 	statusOld := strings.TrimSpace(string(status[:]))
 
 	// then clean all previous tokens
 	if statusOld == "0" || statusOld == "" {
 		// open file
-		f, _ := os.OpenFile(configuration.Config.TokensDir+"/"+jsonOTP.Username, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+		f, _ := os.OpenFile(configuration.Get().TokensDir+"/"+jsonOTP.Username, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 		defer f.Close()
 
 		// write file with tokens
@@ -124,27 +100,19 @@ func OTPVerify(c *gin.Context) {
 
 		// check error
 		if err != nil {
-			c.JSON(http.StatusBadRequest, structs.Map(response.StatusBadRequest{
-				Code:    400,
-				Message: "clean previous tokens error",
-				Data:    err,
-			}))
+			response.Error(c, http.StatusInternalServerError, response.ErrOTPUpdateFailed, "clean previous tokens error", err)
 			return
 		}
 	}
 
 	// set auth token to valid
 	if !SetTokenValidation(jsonOTP.Username, jsonOTP.Token) {
-		c.JSON(http.StatusBadRequest, structs.Map(response.StatusBadRequest{
-			Code:    400,
-			Message: "token validation set error",
-			Data:    "",
-		}))
+		response.Error(c, http.StatusInternalServerError, response.ErrOTPUpdateFailed, "token validation set error", nil)
 		return
 	}
 
 	// set 2FA to enabled
-	f, _ := os.OpenFile(configuration.Config.SecretsDir+"/"+jsonOTP.Username+"/status", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	f, _ := os.OpenFile(configuration.Get().SecretsDir+"/"+jsonOTP.Username+"/status", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	defer f.Close()
 
 	// write file with 2fa status
@@ -152,20 +120,16 @@ func OTPVerify(c *gin.Context) {
 
 	// check error
 	if err != nil {
-		c.JSON(http.StatusBadRequest, structs.Map(response.StatusBadRequest{
-			Code:    400,
-			Message: "status set error",
-			Data:    err,
-		}))
+		response.Error(c, http.StatusInternalServerError, response.ErrOTPUpdateFailed, "status set error", err)
 		return
 	}
 
-	// response
-	c.JSON(http.StatusOK, structs.Map(response.StatusOK{
-		Code:    200,
-		Message: "OTP verified",
-		Data:    jsonOTP.Token,
-	}))
+	// response, token is not given to scripts with session cookie
+	if configuration.Get().SessionCookie {
+		response.OK(c, "OTP verified", "")
+		return
+	}
+	response.OK(c, "OTP verified", jsonOTP.Token)
 }
 
 func QRCode(c *gin.Context) {
@@ -173,7 +137,7 @@ func QRCode(c *gin.Context) {
 	secret := make([]byte, 20)
 	_, err := rand.Read(secret)
 	if err != nil {
-		logs.Logs.Err("[ERR][2FA] Failed to generate random secret for QRCode: " + err.Error())
+		logs.ForRequest(c, "2FA").With(logs.Fields{"error": err.Error()}).Err("Failed to generate random secret for QRCode")
 	}
 
 	// convert to string
@@ -184,23 +148,19 @@ func QRCode(c *gin.Context) {
 
 	// define issuer
 	account := claims["id"].(string)
-	issuer := configuration.Config.Issuer2FA
+	issuer := configuration.Get().Issuer2FA
 
 	// set secret for user
 	result, setSecret := SetUserSecret(account, secretBase32)
 	if !result {
-		c.JSON(http.StatusBadRequest, structs.Map(response.StatusBadRequest{
-			Code:    400,
-			Message: "user secret set error",
-			Data:    "",
-		}))
+		response.Error(c, http.StatusInternalServerError, response.ErrOTPUpdateFailed, "user secret set error", nil)
 		return
 	}
 
 	// define URL
 	URL, err := url.Parse("otpauth://totp")
 	if err != nil {
-		logs.Logs.Err("[ERR][2FA] Failed to parse URL for QRCode: " + err.Error())
+		logs.ForRequest(c, "2FA").With(logs.Fields{"error": err.Error()}).Err("Failed to parse URL for QRCode")
 	}
 
 	// add params
@@ -216,11 +176,7 @@ func QRCode(c *gin.Context) {
 	URL.RawQuery = params.Encode()
 
 	// response
-	c.JSON(http.StatusOK, structs.Map(response.StatusOK{
-		Code:    200,
-		Message: "QR code string",
-		Data:    gin.H{"url": URL.String(), "key": setSecret},
-	}))
+	response.OK(c, "QR code string", gin.H{"url": URL.String(), "key": setSecret})
 }
 
 func Get2FAStatus(c *gin.Context) {
@@ -228,7 +184,7 @@ func Get2FAStatus(c *gin.Context) {
 	claims := jwt.ExtractClaims(c)
 
 	// get status
-	status, err := os.ReadFile(configuration.Config.SecretsDir + "/" + claims["id"].(string) + "/status")
+	status, err := os.ReadFile(configuration.Get().SecretsDir + "/" + claims["id"].(string) + "/status")
 	statusS := strings.TrimSpace(string(status[:]))
 
 	// handle response
@@ -239,11 +195,7 @@ func Get2FAStatus(c *gin.Context) {
 	}
 
 	// return response
-	c.JSON(http.StatusOK, structs.Map(response.StatusOK{
-		Code:    200,
-		Message: message,
-		Data:    statusS == "1",
-	}))
+	response.OK(c, message, statusS == "1")
 }
 
 func Del2FAStatus(c *gin.Context) {
@@ -251,18 +203,18 @@ func Del2FAStatus(c *gin.Context) {
 	claims := jwt.ExtractClaims(c)
 
 	// revocate secret
-	errRevocate := os.Remove(configuration.Config.SecretsDir + "/" + claims["id"].(string) + "/secret")
+	errRevocate := os.Remove(configuration.Get().SecretsDir + "/" + claims["id"].(string) + "/secret")
+	if os.IsNotExist(errRevocate) {
+		response.Error(c, http.StatusNotFound, response.ErrOTPNotFound, "user secret not found", nil)
+		return
+	}
 	if errRevocate != nil {
-		c.JSON(http.StatusBadRequest, structs.Map(response.StatusBadRequest{
-			Code:    403,
-			Message: "error in revocate 2FA for user",
-			Data:    nil,
-		}))
+		response.Error(c, http.StatusInternalServerError, response.ErrOTPUpdateFailed, "error in revocate 2FA for user", errRevocate)
 		return
 	}
 
 	// set 2FA to disabled
-	f, _ := os.OpenFile(configuration.Config.SecretsDir+"/"+claims["id"].(string)+"/status", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	f, _ := os.OpenFile(configuration.Get().SecretsDir+"/"+claims["id"].(string)+"/status", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	defer f.Close()
 
 	// write file with tokens
@@ -270,25 +222,17 @@ func Del2FAStatus(c *gin.Context) {
 
 	// check error
 	if err != nil {
-		c.JSON(http.StatusBadRequest, structs.Map(response.StatusBadRequest{
-			Code:    400,
-			Message: "2FA not revocated",
-			Data:    "",
-		}))
+		response.Error(c, http.StatusInternalServerError, response.ErrOTPUpdateFailed, "2FA not revocated", err)
 		return
 	}
 
 	// response
-	c.JSON(http.StatusOK, structs.Map(response.StatusOK{
-		Code:    200,
-		Message: "2FA revocate successfully",
-		Data:    "",
-	}))
+	response.OK(c, "2FA revocate successfully", nil)
 }
 
 func GetUserSecret(username string) string {
 	// get secret
-	secret, err := os.ReadFile(configuration.Config.SecretsDir + "/" + username + "/secret")
+	secret, err := os.ReadFile(configuration.Get().SecretsDir + "/" + username + "/secret")
 
 	// handle error
 	if err != nil {
@@ -301,17 +245,17 @@ func GetUserSecret(username string) string {
 
 func SetUserSecret(username string, secret string) (bool, string) {
 	// get secret
-	secretB, _ := os.ReadFile(configuration.Config.SecretsDir + "/" + username + "/secret")
+	secretB, _ := os.ReadFile(configuration.Get().SecretsDir + "/" + username + "/secret")
 
 	// check error
 	if len(string(secretB[:])) == 0 {
 		// check if dir exists, otherwise create it
-		if _, errD := os.Stat(configuration.Config.SecretsDir + "/" + username); os.IsNotExist(errD) {
-			_ = os.MkdirAll(configuration.Config.SecretsDir+"/"+username, 0700)
+		if _, errD := os.Stat(configuration.Get().SecretsDir + "/" + username); os.IsNotExist(errD) {
+			_ = os.MkdirAll(configuration.Get().SecretsDir+"/"+username, 0700)
 		}
 
 		// open file
-		f, _ := os.OpenFile(configuration.Config.SecretsDir+"/"+username+"/secret", os.O_WRONLY|os.O_CREATE, 0600)
+		f, _ := os.OpenFile(configuration.Get().SecretsDir+"/"+username+"/secret", os.O_WRONLY|os.O_CREATE, 0600)
 		defer f.Close()
 
 		// write file with secret
@@ -330,7 +274,7 @@ func SetUserSecret(username string, secret string) (bool, string) {
 
 func CheckTokenValidation(username string, token string) bool {
 	// read whole file
-	secrestListB, err := ioutil.ReadFile(configuration.Config.TokensDir + "/" + username)
+	secrestListB, err := ioutil.ReadFile(configuration.Get().TokensDir + "/" + username)
 	if err != nil {
 		return false
 	}
@@ -342,7 +286,7 @@ func CheckTokenValidation(username string, token string) bool {
 
 func SetTokenValidation(username string, token string) bool {
 	// open file
-	f, _ := os.OpenFile(configuration.Config.TokensDir+"/"+username, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	f, _ := os.OpenFile(configuration.Get().TokensDir+"/"+username, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
 	defer f.Close()
 
 	// write file with tokens
@@ -358,7 +302,7 @@ func SetTokenValidation(username string, token string) bool {
 
 func DelTokenValidation(username string, token string) bool {
 	// read whole file
-	secrestListB, errR := ioutil.ReadFile(configuration.Config.TokensDir + "/" + username)
+	secrestListB, errR := ioutil.ReadFile(configuration.Get().TokensDir + "/" + username)
 	if errR != nil {
 		return false
 	}
@@ -368,7 +312,7 @@ func DelTokenValidation(username string, token string) bool {
 	res := strings.Replace(secrestList, token, "", 1)
 
 	// open file
-	f, _ := os.OpenFile(configuration.Config.TokensDir+"/"+username, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	f, _ := os.OpenFile(configuration.Get().TokensDir+"/"+username, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	defer f.Close()
 
 	// write file with tokens
@@ -383,21 +327,43 @@ func DelTokenValidation(username string, token string) bool {
 
 }
 
+func ActiveSessions() int {
+	// list users token files
+	files, err := ioutil.ReadDir(configuration.Get().TokensDir)
+	if err != nil {
+		return 0
+	}
+
+	// count valid tokens not yet expired
+	count := 0
+	parser := jwtl.Parser{}
+	for _, file := range files {
+		tokensB, err := ioutil.ReadFile(configuration.Get().TokensDir + "/" + file.Name())
+		if err != nil {
+			continue
+		}
+		for _, tokenString := range strings.Fields(string(tokensB)) {
+			claims := jwtl.MapClaims{}
+			if _, _, err := parser.ParseUnverified(tokenString, claims); err == nil && claims.VerifyExpiresAt(time.Now().Unix(), true) {
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
 func ValidateAuth(tokenString string, ensureTokenExists bool) bool {
 	// convert token string and validate it
 	if tokenString != "" {
 		token, err := jwtl.Parse(tokenString, func(token *jwtl.Token) (interface{}, error) {
-			// validate the alg
-			if _, ok := token.Method.(*jwtl.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-			}
-
-			// return secret
-			return []byte(configuration.Config.SecretJWT), nil
+			// return keyring key, the alg is validated against the key
+			kid, _ := token.Header["kid"].(string)
+			return keyring.Lookup(kid, token.Method.Alg())
 		})
 
 		if err != nil {
-			logs.Logs.Err("[ERR][JWT] error in JWT token validation: " + err.Error())
+			logs.For("JWT").With(logs.Fields{"error": err.Error()}).Err("error in JWT token validation")
 			return false
 		}
 
@@ -407,14 +373,14 @@ func ValidateAuth(tokenString string, ensureTokenExists bool) bool {
 					username := claims["id"].(string)
 
 					if !CheckTokenValidation(username, tokenString) {
-						logs.Logs.Err("[ERR][JWT] error JWT token not found: " + err.Error())
+						logs.For("JWT").With(logs.Fields{"error": err.Error()}).Err("error JWT token not found")
 						return false
 					}
 				}
 				return true
 			}
 		} else {
-			logs.Logs.Err("[ERR][JWT] error in JWT token claims: " + err.Error())
+			logs.For("JWT").With(logs.Fields{"error": err.Error()}).Err("error in JWT token claims")
 			return false
 		}
 	}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package methods
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/metrics"
+	"github.com/NethServer/ns-api-server/response"
+	"github.com/NethServer/ns-api-server/ui"
+)
+
+// server version, set at build time with -ldflags "-X github.com/NethServer/ns-api-server/methods.Version=<version>"
+var Version = "dev"
+
+var startTime = time.Now()
+
+// clocks before this date are not synchronized, like after a reset to epoch
+var minimumClock = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var ubusTimeout = 5 * time.Second
+
+type readinessCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func GetHealth(c *gin.Context) {
+	// server is alive if it can answer
+	response.OK(c, "server is alive", nil)
+}
+
+func GetReady(c *gin.Context) {
+	// run all checks
+	checks := map[string]error{
+		"ubus":        checkUBus(),
+		"secrets_dir": checkWritable(configuration.Get().SecretsDir),
+		"tokens_dir":  checkWritable(configuration.Get().TokensDir),
+		"clock":       checkClock(),
+	}
+
+	// embedded web ui doesn't need a directory
+	if !ui.Embedded() {
+		checks["static_dir"] = checkDirectory(configuration.Get().StaticDir)
+	}
+
+	// collect results
+	ready := true
+	results := map[string]readinessCheck{}
+	for name, err := range checks {
+		if err != nil {
+			ready = false
+			results[name] = readinessCheck{Status: "fail", Error: err.Error()}
+		} else {
+			results[name] = readinessCheck{Status: "ok"}
+		}
+	}
+
+	if !ready {
+		logs.ForRequest(c, "HTTP").With(logs.Fields{"checks": results}).Warning("readiness check failed")
+		response.Error(c, http.StatusServiceUnavailable, response.ErrNotReady, "server is not ready", results)
+		return
+	}
+
+	response.OK(c, "server is ready", results)
+}
+
+func checkUBus() error {
+	// list session object, used for logins
+	ctx, cancel := context.WithTimeout(context.Background(), ubusTimeout)
+	defer cancel()
+
+	metrics.ExecsInFlight.Inc()
+	defer metrics.ExecsInFlight.Dec()
+	if out, err := exec.CommandContext(ctx, "/bin/ubus", "list", "session").CombinedOutput(); err != nil {
+		return errors.Wrap(err, "ubus not reachable: "+string(out))
+	}
+
+	return nil
+}
+
+func checkWritable(dir string) error {
+	// create and remove a temporary file
+	f, err := ioutil.TempFile(dir, ".ready-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	return os.Remove(f.Name())
+}
+
+func checkDirectory(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New(dir + " is not a directory")
+	}
+
+	return nil
+}
+
+func checkClock() error {
+	// totp codes are valid only with a synchronized clock
+	if time.Now().Before(minimumClock) {
+		return errors.New("system clock is not synchronized: " + time.Now().UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func GetDiagnostics(c *gin.Context) {
+	// read build information
+	build := gin.H{"version": Version, "go_version": runtime.Version(), "os": runtime.GOOS, "arch": runtime.GOARCH}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		build["module"] = info.Main.Path
+		build["module_version"] = info.Main.Version
+		deps := map[string]string{}
+		for _, dep := range info.Deps {
+			deps[dep.Path] = dep.Version
+		}
+		build["dependencies"] = deps
+	}
+
+	// read runtime stats
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+
+	response.OK(c, "server diagnostics", gin.H{
+		"build":      build,
+		"started":    startTime.UTC().Format(time.RFC3339),
+		"uptime":     time.Since(startTime).Round(time.Second).String(),
+		"goroutines": runtime.NumGoroutine(),
+		"cpus":       runtime.NumCPU(),
+		"memory": gin.H{
+			"alloc_bytes": memory.Alloc,
+			"sys_bytes":   memory.Sys,
+			"gc_cycles":   memory.NumGC,
+		},
+		"sessions":      ActiveSessions(),
+		"configuration": configuration.Summary(),
+	})
+}
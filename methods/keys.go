@@ -0,0 +1,50 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package methods
+
+import (
+	"net/http"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/keyring"
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/response"
+)
+
+func GetJWKS(c *gin.Context) {
+	// return public keys in JWKS format
+	c.JSON(http.StatusOK, keyring.PublicKeys())
+}
+
+func GetKeys(c *gin.Context) {
+	// return keyring without secrets
+	response.OK(c, "keyring keys", keyring.Keys())
+}
+
+func RotateKeys(c *gin.Context) {
+	// get claims from token
+	claims := jwt.ExtractClaims(c)
+
+	// rotate signing key
+	key, err := keyring.Rotate()
+	if err != nil {
+		logs.ForRequest(c, "KEYRING").With(logs.Fields{"error": err.Error()}).Err("keyring rotation failed")
+		response.Error(c, http.StatusInternalServerError, response.ErrKeyringFailed, "keyring rotation failed", err.Error())
+		return
+	}
+
+	// write logs
+	logs.ForRequest(c, "KEYRING").With(logs.Fields{"user": claims["id"], "ip": c.ClientIP()}).Info("keyring rotated by user")
+
+	// response
+	response.OK(c, "keyring rotated successfully", key)
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package methods
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/metrics"
+	"github.com/NethServer/ns-api-server/response"
+	"github.com/NethServer/ns-api-server/utils"
+)
+
+func GetMetrics(c *gin.Context) {
+	// check client address
+	if !utils.AllowedIP(configuration.Get().MetricsAllowedNetworks, c.ClientIP()) {
+		logs.ForRequest(c, "HTTP").With(logs.Fields{"ip": c.ClientIP()}).Info("metrics request from address not allowed")
+		response.Error(c, http.StatusForbidden, response.ErrForbidden, "metrics not allowed from this address", nil)
+		return
+	}
+
+	// check bearer token, if configured
+	if token := configuration.Get().MetricsToken; token != "" {
+		given := c.GetHeader("Authorization")
+		if subtle.ConstantTimeCompare([]byte(given), []byte("Bearer "+token)) != 1 {
+			logs.ForRequest(c, "HTTP").With(logs.Fields{"ip": c.ClientIP()}).Info("metrics request with invalid token")
+			c.Header("WWW-Authenticate", "Bearer")
+			response.Error(c, http.StatusUnauthorized, response.ErrUnauthorized, "metrics token invalid", nil)
+			return
+		}
+	}
+
+	// write metrics in prometheus text format
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	metrics.Write(c.Writer)
+}
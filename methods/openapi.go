@@ -0,0 +1,28 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package methods
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/openapi"
+)
+
+func GetOpenAPI(c *gin.Context) {
+	// describe registered routes
+	c.JSON(http.StatusOK, openapi.Spec(Version))
+}
+
+func GetExplorer(c *gin.Context) {
+	// explorer reads the spec from openapi.json
+	c.Data(http.StatusOK, "text/html; charset=utf-8", openapi.Explorer)
+}
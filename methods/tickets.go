@@ -0,0 +1,52 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package methods
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/models"
+	"github.com/NethServer/ns-api-server/response"
+	"github.com/NethServer/ns-api-server/tickets"
+)
+
+func CreateTicket(c *gin.Context) {
+	// get payload
+	var jsonTicket models.TicketJSON
+	if err := c.ShouldBindBodyWith(&jsonTicket, binding.JSON); err != nil {
+		response.Error(c, http.StatusBadRequest, response.ErrBadRequest, "request fields malformed", err.Error())
+		return
+	}
+
+	// tickets are valid only for api routes, not to create other tickets
+	if !strings.HasPrefix(jsonTicket.Path, "/api/") || path.Clean(jsonTicket.Path) != jsonTicket.Path || strings.HasSuffix(jsonTicket.Path, "/tickets") {
+		response.Error(c, http.StatusBadRequest, response.ErrValidationFailed, "ticket creation failed", "path must be an api route, without query")
+		return
+	}
+
+	// get claims from token
+	claims := jwt.ExtractClaims(c)
+
+	// create ticket with current claims
+	ticket, secret := tickets.Create(jsonTicket.Path, claims)
+
+	// write logs
+	logs.ForRequest(c, "AUTH").With(logs.Fields{"user": claims["id"], "path": ticket.Path, "ip": c.ClientIP()}).Info("ticket created")
+
+	// response, append ticket to path as ticket query parameter
+	response.Created(c, "ticket created", gin.H{"ticket": secret, "path": ticket.Path, "expire": ticket.Expire})
+}
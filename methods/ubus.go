@@ -12,13 +12,18 @@ package methods
 import (
 	"encoding/json"
 	"net/http"
+	"os"
 	"os/exec"
+	"path"
+	"time"
 
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/metrics"
 	"github.com/NethServer/ns-api-server/models"
 	"github.com/NethServer/ns-api-server/response"
 
 	"github.com/Jeffail/gabs/v2"
-	"github.com/fatih/structs"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 )
@@ -27,27 +32,40 @@ func UBusCallAction(c *gin.Context) {
 	// parse request fields
 	var jsonUBusCall models.UBusCallJSON
 	if err := c.ShouldBindBodyWith(&jsonUBusCall, binding.JSON); err != nil {
-		c.JSON(http.StatusBadRequest, structs.Map(response.StatusBadRequest{
-			Code:    400,
-			Message: "request fields malformed",
-			Data:    err.Error(),
-		}))
+		response.Error(c, http.StatusBadRequest, response.ErrBadRequest, "request fields malformed", err.Error())
 		return
 	}
 
+	// pass request id in payload to backends supporting it
+	requestID := c.GetString(logs.RequestIDKey)
+	if requestID != "" && requestIDObject(jsonUBusCall.Path) {
+		payload, ok := jsonUBusCall.Payload.(map[string]interface{})
+		if !ok && jsonUBusCall.Payload == nil {
+			payload, ok = map[string]interface{}{}, true
+		}
+		if ok {
+			payload["_meta"] = map[string]interface{}{"request_id": requestID}
+			jsonUBusCall.Payload = payload
+		}
+	}
+
 	// convert payload to JSON
 	jsonPayload, _ := json.Marshal(jsonUBusCall.Payload)
 
-	// execute login command on ubus
-	out, err := exec.Command("/bin/ubus", "-S", "call", jsonUBusCall.Path, jsonUBusCall.Method, string(jsonPayload[:])).Output()
+	// execute login command on ubus, with request id in environment
+	start := time.Now()
+	metrics.ExecsInFlight.Inc()
+	cmd := exec.Command("/bin/ubus", "-S", "call", jsonUBusCall.Path, jsonUBusCall.Method, string(jsonPayload[:]))
+	cmd.Env = append(os.Environ(), "REQUEST_ID="+requestID)
+	out, err := cmd.Output()
+	metrics.ExecsInFlight.Dec()
+	metrics.UBusDuration.Observe(time.Since(start).Seconds(), jsonUBusCall.Path, jsonUBusCall.Method)
 
 	// check errors
 	if err != nil {
-		c.JSON(http.StatusBadRequest, structs.Map(response.StatusBadRequest{
-			Code:    400,
-			Message: "ubus call action failed",
-			Data:    err.Error(),
-		}))
+		metrics.UBusCalls.Inc(jsonUBusCall.Path, jsonUBusCall.Method, "error")
+		logs.ForRequest(c, "UBUS").With(logs.Fields{"object": jsonUBusCall.Path, "method": jsonUBusCall.Method, "error": err.Error(), "output": string(out)}).Info("ubus call failed")
+		response.Error(c, http.StatusBadRequest, response.ErrUBusCallFailed, "ubus call action failed", err.Error())
 		return
 	}
 
@@ -57,18 +75,22 @@ func UBusCallAction(c *gin.Context) {
 	// check errors in response
 	errorMessage, errFound := jsonParsed.Path("error").Data().(string)
 	if errFound {
-		c.JSON(http.StatusBadRequest, structs.Map(response.StatusBadRequest{
-			Code:    400,
-			Message: "ubus call action failed",
-			Data:    "payload: " + errorMessage,
-		}))
+		metrics.UBusCalls.Inc(jsonUBusCall.Path, jsonUBusCall.Method, "error")
+		logs.ForRequest(c, "UBUS").With(logs.Fields{"object": jsonUBusCall.Path, "method": jsonUBusCall.Method, "error": errorMessage}).Info("ubus call failed")
+		response.Error(c, http.StatusBadRequest, response.ErrUBusCallFailed, "ubus call action failed", "payload: "+errorMessage)
 		return
 	}
 
 	// return 200 OK with data
-	c.JSON(http.StatusOK, structs.Map(response.StatusOK{
-		Code:    200,
-		Message: "ubus call action success",
-		Data:    jsonParsed,
-	}))
+	metrics.UBusCalls.Inc(jsonUBusCall.Path, jsonUBusCall.Method, "success")
+	response.OK(c, "ubus call action success", jsonParsed)
+}
+
+func requestIDObject(object string) bool {
+	for _, pattern := range configuration.Get().UBusRequestIDObjects {
+		if match, _ := path.Match(pattern, object); match {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"time"
+)
+
+var (
+	HTTPRequests   = NewCounter("ns_api_server_http_requests_total", "HTTP requests by route and status.", "method", "route", "status")
+	HTTPDuration   = NewHistogram("ns_api_server_http_request_duration_seconds", "HTTP request latency by route.", DefaultBuckets, "method", "route")
+	HTTPDeprecated = NewCounter("ns_api_server_http_deprecated_requests_total", "HTTP requests to deprecated routes.", "method", "route")
+
+	UBusCalls    = NewCounter("ns_api_server_ubus_calls_total", "ubus calls by object, method and result.", "object", "method", "result")
+	UBusDuration = NewHistogram("ns_api_server_ubus_call_duration_seconds", "ubus call latency by object and method.", DefaultBuckets, "object", "method")
+
+	RateLimited = NewCounter("ns_api_server_rate_limit_requests_total", "Requests checked by rate limiter, by route group and result.", "group", "result")
+
+	Logins      = NewCounter("ns_api_server_logins_total", "Login attempts by authentication method and result.", "auth", "result")
+	OTPFailures = NewCounter("ns_api_server_otp_failures_total", "Failed OTP verifications.")
+
+	ExecsInFlight = NewGauge("ns_api_server_execs_in_flight", "External commands currently running.")
+)
+
+var startTime = time.Now()
+
+// go runtime and process stats, read on each scrape
+type runtimeCollector struct{}
+
+func init() {
+	register(runtimeCollector{})
+}
+
+func (runtimeCollector) write(w io.Writer) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	header(w, "go_info", "Go version used to build the server.", "gauge")
+	fmt.Fprintf(w, "go_info{version=\"%s\"} 1\n", escape(runtime.Version()))
+
+	gauge := func(name string, help string, value float64) {
+		header(w, name, help, "gauge")
+		fmt.Fprintf(w, "%s %s\n", name, formatValue(value))
+	}
+	gauge("go_goroutines", "Number of goroutines.", float64(runtime.NumGoroutine()))
+	gauge("go_memstats_alloc_bytes", "Bytes of allocated heap objects.", float64(stats.Alloc))
+	gauge("go_memstats_heap_inuse_bytes", "Bytes in in-use heap spans.", float64(stats.HeapInuse))
+	gauge("go_memstats_heap_objects", "Number of allocated heap objects.", float64(stats.HeapObjects))
+	gauge("go_memstats_sys_bytes", "Bytes of memory obtained from the OS.", float64(stats.Sys))
+	gauge("go_gc_pause_last_seconds", "Duration of the last garbage collection pause.", float64(stats.PauseNs[(stats.NumGC+255)%256])/1e9)
+
+	header(w, "go_gc_cycles_total", "Completed garbage collection cycles.", "counter")
+	fmt.Fprintf(w, "go_gc_cycles_total %d\n", stats.NumGC)
+
+	gauge("process_start_time_seconds", "Start time of the process since unix epoch.", float64(startTime.Unix()))
+	if fds, err := ioutil.ReadDir("/proc/self/fd"); err == nil {
+		gauge("process_open_fds", "Number of open file descriptors.", float64(len(fds)))
+	}
+}
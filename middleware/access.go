@@ -0,0 +1,52 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package middleware
+
+import (
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/logs"
+)
+
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		// process request
+		c.Next()
+
+		// use route pattern, fallback to path for static files
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		fields := logs.Fields{
+			"ip":      c.ClientIP(),
+			"method":  c.Request.Method,
+			"route":   route,
+			"status":  c.Writer.Status(),
+			"size":    c.Writer.Size(),
+			"latency": time.Since(start).String(),
+		}
+		if user, ok := jwt.ExtractClaims(c)[identityKey]; ok {
+			fields["user"] = user
+		}
+
+		// server errors are always logged, other requests only at debug level
+		if c.Writer.Status() >= 500 {
+			logs.ForRequest(c, "HTTP").With(fields).Err("request failed")
+		} else {
+			logs.ForRequest(c, "HTTP").With(fields).Debug("request completed")
+		}
+	}
+}
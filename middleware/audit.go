@@ -0,0 +1,132 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/audit"
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/models"
+	"github.com/NethServer/ns-api-server/redact"
+)
+
+func Audit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// read only requests are not audited
+		if c.Request.Method == "GET" || c.Request.Method == "HEAD" || c.Request.Method == "OPTIONS" {
+			c.Next()
+			return
+		}
+
+		// extract body
+		var buf bytes.Buffer
+		tee := io.TeeReader(c.Request.Body, &buf)
+		body, _ := ioutil.ReadAll(tee)
+		c.Request.Body = ioutil.NopCloser(&buf)
+
+		// process request
+		c.Next()
+
+		// audit only authenticated users
+		claims := jwt.ExtractClaims(c)
+		user, _ := claims[identityKey].(string)
+		if user == "" {
+			return
+		}
+
+		entry := audit.Entry{
+			User:    user,
+			Role:    role(claims["role"]),
+			Auth:    method(claims),
+			IP:      c.ClientIP(),
+			Request: c.GetString(logs.RequestIDKey),
+			Action:  c.Request.Method + " " + c.FullPath(),
+			Status:  c.Writer.Status(),
+			Result:  result(c.Writer.Status()),
+		}
+
+		// record ubus object and method, with masked payload
+		if strings.HasSuffix(c.FullPath(), "/ubus/call") {
+			var jsonUBusCall models.UBusCallJSON
+			_ = json.Unmarshal(body, &jsonUBusCall)
+			entry.Action = "ubus.call"
+			entry.Object = jsonUBusCall.Path
+			entry.Method = jsonUBusCall.Method
+			if jsonUBusCall.Payload != nil {
+				entry.Payload, _ = json.Marshal(redact.UBus(jsonUBusCall.Path, jsonUBusCall.Method, jsonUBusCall.Payload))
+			}
+		} else if len(body) > 0 {
+			entry.Payload = redactBody(c, body)
+		}
+
+		audit.Record(entry)
+	}
+}
+
+func auditSession(c *gin.Context, action string, user string, userRole string, auth string, status int) {
+	audit.Record(audit.Entry{
+		User:    user,
+		Role:    userRole,
+		Auth:    auth,
+		IP:      c.ClientIP(),
+		Request: c.GetString(logs.RequestIDKey),
+		Action:  action,
+		Status:  status,
+		Result:  result(status),
+	})
+}
+
+func role(value interface{}) string {
+	// users without role are admin
+	if role, _ := value.(string); role != "" {
+		return role
+	}
+	return "admin"
+}
+
+func method(claims jwt.MapClaims) string {
+	// tokens have no auth claim
+	if auth, _ := claims["auth"].(string); auth != "" {
+		return auth
+	}
+	return "jwt"
+}
+
+func result(status int) string {
+	if status >= http.StatusBadRequest {
+		return "failure"
+	}
+	return "success"
+}
+
+func redactBody(c *gin.Context, body []byte) []byte {
+	// use ubus method rules for ubus calls
+	if strings.HasSuffix(c.FullPath(), "/ubus/call") {
+		var jsonUBusCall map[string]interface{}
+		if err := json.Unmarshal(body, &jsonUBusCall); err == nil {
+			object, _ := jsonUBusCall["path"].(string)
+			method, _ := jsonUBusCall["method"].(string)
+			jsonUBusCall["payload"] = redact.UBus(object, method, jsonUBusCall["payload"])
+			redacted, _ := json.Marshal(redact.Value(jsonUBusCall))
+			return redacted
+		}
+	}
+
+	return redact.JSON(body)
+}
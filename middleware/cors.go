@@ -0,0 +1,84 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package middleware
+
+import (
+	"sync"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/logs"
+)
+
+// headers sent by UIs and scripts
+var corsAllowHeaders = []string{"Authorization", "Content-Type", "Accept", "X-API-Key", "X-Request-ID", "X-CSRF-Token"}
+
+// headers readable by browser scripts
+var corsExposeHeaders = []string{
+	"X-Request-ID", "Content-Disposition", "Retry-After", "Deprecation", "Sunset", "Link",
+	"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy",
+}
+
+// current policy, rebuilt on reload
+var corsHandler gin.HandlerFunc
+var corsMutex sync.RWMutex
+
+func CORS() gin.HandlerFunc {
+	loadCORS()
+	configuration.OnReload(loadCORS)
+
+	return func(c *gin.Context) {
+		corsMutex.RLock()
+		handler := corsHandler
+		corsMutex.RUnlock()
+
+		if handler == nil {
+			c.Next()
+			return
+		}
+		handler(c)
+	}
+}
+
+func loadCORS() {
+	// keep development setups working, all origins are allowed in debug mode
+	origins := configuration.Get().CORSAllowedOrigins
+	if len(origins) == 0 && gin.Mode() == gin.DebugMode {
+		origins = []string{"*"}
+	}
+
+	// no origins, no cross origin requests
+	var handler gin.HandlerFunc
+	if len(origins) > 0 {
+		corsConf := cors.DefaultConfig()
+		corsConf.AllowHeaders = corsAllowHeaders
+		corsConf.ExposeHeaders = corsExposeHeaders
+		corsConf.AllowCredentials = configuration.Get().CORSAllowCredentials
+		corsConf.MaxAge = configuration.Get().CORSMaxAge
+		corsConf.AllowWildcard = true
+		if len(origins) == 1 && origins[0] == "*" {
+			corsConf.AllowAllOrigins = true
+		} else {
+			corsConf.AllowOrigins = origins
+		}
+
+		if err := corsConf.Validate(); err != nil {
+			logs.For("HTTP").With(logs.Fields{"error": err.Error()}).Err("invalid cors configuration, previous one is kept")
+			return
+		}
+		handler = cors.New(corsConf)
+	}
+
+	corsMutex.Lock()
+	corsHandler = handler
+	corsMutex.Unlock()
+}
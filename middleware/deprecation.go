@@ -0,0 +1,78 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/metrics"
+)
+
+// deprecation of a route or group, zero times are not announced
+type Deprecation struct {
+	Since     time.Time
+	Sunset    time.Time
+	Successor string // path prefix replacing the deprecated one
+	Prefix    string // deprecated path prefix
+}
+
+// read on each request, to follow configuration reloads
+type DeprecationFunc func() Deprecation
+
+func Deprecated(deprecation DeprecationFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := deprecation()
+		if d.Since.IsZero() && d.Sunset.IsZero() {
+			c.Next()
+			return
+		}
+
+		// announce deprecation as in RFC 9745 and RFC 8594
+		if !d.Since.IsZero() {
+			c.Header("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10))
+		}
+		if !d.Sunset.IsZero() {
+			c.Header("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if d.Successor != "" {
+			successor := d.Successor + strings.TrimPrefix(c.Request.URL.Path, d.Prefix)
+			c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+		}
+
+		// track clients still using it
+		logs.ForRequest(c, "HTTP").With(logs.Fields{
+			"method":     c.Request.Method,
+			"route":      c.FullPath(),
+			"ip":         c.ClientIP(),
+			"user_agent": c.Request.UserAgent(),
+		}).Info("deprecated route called")
+		metrics.HTTPDeprecated.Inc(c.Request.Method, c.FullPath())
+
+		c.Next()
+	}
+}
+
+func LegacyAPI(prefix string, successor string) gin.HandlerFunc {
+	return Deprecated(func() Deprecation {
+		return Deprecation{
+			Since:     configuration.Get().APILegacyDeprecation,
+			Sunset:    configuration.Get().APILegacySunset,
+			Successor: successor,
+			Prefix:    prefix,
+		}
+	})
+}
@@ -14,20 +14,24 @@ import (
 	"encoding/json"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"strings"
 	"time"
 
-	"github.com/fatih/structs"
 	"github.com/gin-gonic/gin"
-	"github.com/nqd/flat"
 
 	jwt "github.com/appleboy/gin-jwt/v2"
 
+	"github.com/NethServer/ns-api-server/apikeys"
+	"github.com/NethServer/ns-api-server/authenticator"
 	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/keyring"
 	"github.com/NethServer/ns-api-server/logs"
 	"github.com/NethServer/ns-api-server/methods"
+	"github.com/NethServer/ns-api-server/metrics"
 	"github.com/NethServer/ns-api-server/models"
 	"github.com/NethServer/ns-api-server/response"
+	"github.com/NethServer/ns-api-server/tickets"
 )
 
 type login struct {
@@ -37,6 +41,14 @@ type login struct {
 
 var jwtMiddleware *jwt.GinJWTMiddleware
 var identityKey = "id"
+var apiKeyHeader = "X-API-Key"
+var apiKeyContextKey = "API_KEY"
+var authErrorKey = "AUTH_ERROR"
+
+// routes allowed to roles other than admin
+var roleRoutes = map[string][]string{
+	"auditor": {"/refresh", "/2fa", "/2fa/qr-code", "/audit", "/tickets"},
+}
 
 func InstanceJWT() *jwt.GinJWTMiddleware {
 	if jwtMiddleware == nil {
@@ -50,7 +62,7 @@ func InitJWT() *jwt.GinJWTMiddleware {
 	// define jwt middleware
 	authMiddleware, errDefine := jwt.New(&jwt.GinJWTMiddleware{
 		Realm:       "nethserver",
-		Key:         []byte(configuration.Config.SecretJWT),
+		KeyFunc:     keyring.KeyFunc,
 		Timeout:     time.Hour * 24, // 1 day
 		MaxRefresh:  time.Hour * 24, // 1 day
 		IdentityKey: identityKey,
@@ -66,21 +78,26 @@ func InitJWT() *jwt.GinJWTMiddleware {
 			password := loginVals.Password
 
 			// check login
-			err := methods.CheckAuthentication(username, password)
+			role, err := methods.CheckAuthentication(username, password)
 			if err != nil {
 				// login fail action
-				logs.Logs.Info("[INFO][AUTH] authentication failed for user " + username + ": " + err.Error())
+				logs.ForRequest(c, "AUTH").With(logs.Fields{"user": username, "ip": c.ClientIP(), "error": err.Error()}).Info("authentication failed")
+				auditSession(c, "login", username, "", "password", http.StatusUnauthorized)
+				metrics.Logins.Inc("password", "failure")
 
 				// return JWT error
 				return nil, jwt.ErrFailedAuthentication
 			}
 
 			// login ok action
-			logs.Logs.Info("[INFO][AUTH] authentication success for user " + username)
+			logs.ForRequest(c, "AUTH").With(logs.Fields{"user": username, "ip": c.ClientIP()}).Info("authentication success")
+			auditSession(c, "login", username, role, "password", http.StatusOK)
+			metrics.Logins.Inc("password", "success")
 
 			// return user auth model
 			return &models.UserAuthorizations{
 				Username: username,
+				Role:     role,
 			}, nil
 
 		},
@@ -93,7 +110,7 @@ func InitJWT() *jwt.GinJWTMiddleware {
 				// create claims map
 				return jwt.MapClaims{
 					identityKey: user.Username,
-					"role":      "",
+					"role":      user.Role,
 					"actions":   []string{},
 					"2fa":       required,
 				}
@@ -106,10 +123,16 @@ func InitJWT() *jwt.GinJWTMiddleware {
 			// handle identity and extract claims
 			claims := jwt.ExtractClaims(c)
 
+			// users without role are admin
+			role, _ := claims["role"].(string)
+			if role == "" {
+				role = "admin"
+			}
+
 			// create user object
 			user := &models.UserAuthorizations{
 				Username: claims[identityKey].(string),
-				Role:     "admin",
+				Role:     role,
 				Actions:  nil,
 			}
 
@@ -117,111 +140,99 @@ func InitJWT() *jwt.GinJWTMiddleware {
 			return user
 		},
 		Authorizator: func(data interface{}, c *gin.Context) bool {
-			// check token validation
-			claims, _ := InstanceJWT().GetClaimsFromJWT(c)
-			token, _ := InstanceJWT().ParseToken(c)
+			// get claims
+			claims := jwt.ExtractClaims(c)
 
 			// log request and body
 			reqMethod := c.Request.Method
 			reqURI := c.Request.RequestURI
 
-			// check if token exists
-			if !methods.CheckTokenValidation(claims["id"].(string), token.Raw) {
-				// write logs
-				logs.Logs.Info("[INFO][AUTH] authorization failed for user " + claims["id"].(string) + ". request " + reqMethod + " on " + reqURI)
-
-				// not authorized
-				return false
-			}
-
 			// extract body
-			reqBody := ""
+			var body []byte
 			if reqMethod == "POST" || reqMethod == "PUT" {
-				// extract body
 				var buf bytes.Buffer
 				tee := io.TeeReader(c.Request.Body, &buf)
-				body, _ := ioutil.ReadAll(tee)
+				body, _ = ioutil.ReadAll(tee)
 				c.Request.Body = ioutil.NopCloser(&buf)
+			}
 
-				// convert to map and flat it
-				var jsonDyn map[string]interface{}
-				json.Unmarshal(body, &jsonDyn)
-				in, _ := flat.Flatten(jsonDyn, nil)
-
-				// search for sensitve data, in sensitive list
-				for k, _ := range in {
-					for _, s := range configuration.Config.SensitiveList {
-						if strings.Contains(strings.ToLower(k), strings.ToLower(s)) {
-							in[k] = "XXX"
-						}
-					}
-				}
-
-				// unflat the map
-				out, _ := flat.Unflatten(in, nil)
+			// check if credentials are still valid for this request
+			if !checkAuthorization(c, claims, body) {
+				// write logs
+				logs.ForRequest(c, "AUTH").With(logs.Fields{"user": claims["id"], "ip": c.ClientIP(), "method": reqMethod, "path": reqURI}).Info("authorization failed")
 
-				// convert to json string
-				jsonOut, _ := json.Marshal(out)
+				// not authorized
+				return false
+			}
 
-				// compose string
-				reqBody = string(jsonOut)
+			// mask body
+			reqBody := ""
+			if reqMethod == "POST" || reqMethod == "PUT" {
+				reqBody = string(redactBody(c, body))
 			}
 
-			logs.Logs.Info("[INFO][AUTH] authorization success for user " + claims["id"].(string) + ". request " + reqMethod + " on " + reqURI + " " + reqBody)
+			fields := logs.Fields{"user": claims["id"], "ip": c.ClientIP(), "method": reqMethod, "path": reqURI}
+			if reqBody != "" {
+				fields["body"] = reqBody
+			}
+			logs.ForRequest(c, "AUTH").With(fields).Info("authorization success")
 
 			// authorized
 			return true
 		},
 		LoginResponse: func(c *gin.Context, code int, token string, t time.Time) {
-			//get claims
-			tokenObj, _ := InstanceJWT().ParseTokenString(token)
-			claims := jwt.ExtractClaimsFromToken(tokenObj)
-
-			// set token to valid, if not 2FA
-			if !claims["2fa"].(bool) {
-				methods.SetTokenValidation(claims["id"].(string), token)
-			}
-
-			// write logs
-			logs.Logs.Info("[INFO][AUTH] login response success for user " + claims["id"].(string))
+			// register token
+			registerLogin(c, token)
 
 			// return 200 OK
-			c.JSON(200, gin.H{"code": 200, "expire": t, "token": token})
+			response.OK(c, "login success", tokenResponse(c, token, t))
+		},
+		RefreshResponse: func(c *gin.Context, code int, token string, t time.Time) {
+			response.OK(c, "refresh success", tokenResponse(c, token, t))
 		},
 		LogoutResponse: func(c *gin.Context, code int) {
 			//get claims
-			tokenObj, _ := InstanceJWT().ParseToken(c)
+			tokenObj, err := InstanceJWT().ParseToken(c)
+			if err != nil {
+				unauthorized(c, http.StatusUnauthorized, authErrorCode(c, http.StatusUnauthorized, err.Error()), err.Error())
+				return
+			}
 			claims := jwt.ExtractClaimsFromToken(tokenObj)
 
 			// set token to invalid
 			methods.DelTokenValidation(claims["id"].(string), tokenObj.Raw)
 
 			// write logs
-			logs.Logs.Info("[INFO][AUTH] logout response success for user " + claims["id"].(string))
+			logs.ForRequest(c, "AUTH").With(logs.Fields{"user": claims["id"], "ip": c.ClientIP()}).Info("logout success")
+			auditSession(c, "logout", claims["id"].(string), role(claims["role"]), "jwt", http.StatusOK)
+
+			// remove session cookies
+			if configuration.Get().SessionCookie {
+				clearSessionCookies(c)
+			}
 
 			// reutrn 200 OK
-			c.JSON(200, gin.H{"code": 200})
+			response.OK(c, "logout success", nil)
 		},
 		Unauthorized: func(c *gin.Context, code int, message string) {
 			// write logs
-			logs.Logs.Info("[INFO][AUTH] unauthorized request: " + message)
-
-			// response not authorized
-			c.JSON(code, structs.Map(response.StatusUnauthorized{
-				Code:    code,
-				Message: message,
-				Data:    nil,
-			}))
-			return
+			logs.ForRequest(c, "AUTH").With(logs.Fields{"ip": c.ClientIP(), "path": c.Request.RequestURI, "error": message}).Info("unauthorized request")
+
+			// response not authorized, with error code given by caller or guessed from message
+			errorCode, given := c.Value(authErrorKey).(response.ErrorCode)
+			if !given {
+				errorCode = authErrorCode(c, code, message)
+			}
+			response.Error(c, code, errorCode, message, nil)
 		},
-		TokenLookup:   "header: Authorization, token: jwt",
+		TokenLookup:   tokenLookup(),
 		TokenHeadName: "Bearer",
 		TimeFunc:      time.Now,
 	})
 
 	// check middleware errors
 	if errDefine != nil {
-		logs.Logs.Err("[ERR][AUTH] middleware definition error: " + errDefine.Error())
+		logs.For("AUTH").With(logs.Fields{"error": errDefine.Error()}).Err("middleware definition error")
 	}
 
 	// init middleware
@@ -229,9 +240,234 @@ func InitJWT() *jwt.GinJWTMiddleware {
 
 	// check error on initialization
 	if errInit != nil {
-		logs.Logs.Err("[ERR][AUTH] middleware initialization error: " + errInit.Error())
+		logs.For("AUTH").With(logs.Fields{"error": errInit.Error()}).Err("middleware initialization error")
 	}
 
 	// return object
 	return authMiddleware
 }
+
+func registerLogin(c *gin.Context, token string) {
+	//get claims
+	tokenObj, _ := InstanceJWT().ParseTokenString(token)
+	claims := jwt.ExtractClaimsFromToken(tokenObj)
+
+	// set token to valid, if not 2FA
+	if !claims["2fa"].(bool) {
+		methods.SetTokenValidation(claims["id"].(string), token)
+	}
+
+	// write logs
+	logs.ForRequest(c, "AUTH").With(logs.Fields{"user": claims["id"]}).Info("login success")
+}
+
+func checkAuthorization(c *gin.Context, claims jwt.MapClaims, body []byte) bool {
+	switch claims["auth"] {
+	case "apikey":
+		// api keys can call only ubus methods in their scopes
+		key := c.MustGet(apiKeyContextKey).(apikeys.APIKey)
+		if !strings.HasSuffix(c.FullPath(), "/ubus/call") {
+			return false
+		}
+		var jsonUBusCall models.UBusCallJSON
+		if err := json.Unmarshal(body, &jsonUBusCall); err != nil {
+			return false
+		}
+		return key.Allowed(jsonUBusCall.Path, jsonUBusCall.Method)
+	default:
+		// admin role can access all APIs, other roles only their routes
+		if role, _ := claims["role"].(string); role != "" && role != "admin" && !allowedRoute(role, c.FullPath()) {
+			return false
+		}
+
+		// client certificates are verified on handshake, tickets on redeem
+		if claims["auth"] == "mtls" || claims["auth"] == "ticket" {
+			return true
+		}
+
+		// check if token exists
+		token, err := InstanceJWT().ParseToken(c)
+		return err == nil && methods.CheckTokenValidation(claims["id"].(string), token.Raw)
+	}
+}
+
+func allowedRoute(role string, route string) bool {
+	for _, allowed := range roleRoutes[role] {
+		if strings.HasSuffix(route, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func Authenticate() gin.HandlerFunc {
+	jwtHandler := InstanceJWT().MiddlewareFunc()
+
+	return func(c *gin.Context) {
+		// use ticket, already validated
+		if ticket, ok := c.Value(ticketContextKey).(tickets.Ticket); ok {
+			ticketHandler(c, ticket)
+			return
+		}
+
+		// use api key, if present
+		if secret := c.GetHeader(apiKeyHeader); secret != "" {
+			apiKeyHandler(c, secret)
+			return
+		}
+
+		// use client certificate, if verified and no token is given
+		if c.GetHeader("Authorization") == "" && c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 {
+			if user, ok := authenticator.CertificateUser(c.Request.TLS.VerifiedChains[0][0]); ok {
+				certificateHandler(c, user)
+				return
+			}
+		}
+
+		// fallback to jwt
+		jwtHandler(c)
+	}
+}
+
+func apiKeyHandler(c *gin.Context, secret string) {
+	// validate api key
+	key, err := apikeys.Validate(secret, c.ClientIP())
+	if err != nil {
+		logs.ForRequest(c, "AUTH").With(logs.Fields{"ip": c.ClientIP(), "error": err.Error()}).Info("api key authentication failed")
+		unauthorized(c, http.StatusUnauthorized, response.ErrAPIKeyInvalid, err.Error())
+		return
+	}
+
+	// set claims as for jwt users
+	c.Set(apiKeyContextKey, key)
+	authorizeClaims(c, jwt.MapClaims{
+		identityKey: "apikey:" + key.ID,
+		"role":      "apikey",
+		"actions":   []string{},
+		"auth":      "apikey",
+	})
+}
+
+func certificateHandler(c *gin.Context, user *models.UserAuthorizations) {
+	// set claims as for jwt users
+	authorizeClaims(c, jwt.MapClaims{
+		identityKey: user.Username,
+		"role":      user.Role,
+		"actions":   []string{},
+		"auth":      "mtls",
+	})
+}
+
+func authorizeClaims(c *gin.Context, claims jwt.MapClaims) {
+	mw := InstanceJWT()
+
+	// set identity
+	c.Set("JWT_PAYLOAD", claims)
+	identity := mw.IdentityHandler(c)
+	c.Set(mw.IdentityKey, identity)
+
+	// check authorization
+	if !mw.Authorizator(identity, c) {
+		unauthorized(c, http.StatusForbidden, response.ErrForbidden, mw.HTTPStatusMessageFunc(jwt.ErrForbidden, c))
+		return
+	}
+
+	c.Next()
+}
+
+func unauthorized(c *gin.Context, code int, errorCode response.ErrorCode, message string) {
+	mw := InstanceJWT()
+	c.Set(authErrorKey, errorCode)
+
+	// abort request with jwt realm
+	c.Header("WWW-Authenticate", "JWT realm="+mw.Realm)
+	c.Abort()
+	mw.Unauthorized(c, code, message)
+}
+
+func authErrorCode(c *gin.Context, code int, message string) response.ErrorCode {
+	switch {
+	case code == http.StatusForbidden:
+		return response.ErrForbidden
+	case message == jwt.ErrFailedAuthentication.Error() || message == jwt.ErrMissingLoginValues.Error():
+		return response.ErrAuthenticationFailed
+	case message == jwt.ErrExpiredToken.Error():
+		return response.ErrTokenExpired
+	case c.GetHeader("Authorization") != "":
+		// any other error on a given token comes from its parsing or validation
+		return response.ErrTokenInvalid
+	}
+	return response.ErrUnauthorized
+}
+
+func GenerateToken(data interface{}) (string, time.Time, error) {
+	mw := InstanceJWT()
+
+	// create claims
+	claims := jwt.MapClaims{}
+	for key, value := range mw.PayloadFunc(data) {
+		claims[key] = value
+	}
+	expire := mw.TimeFunc().Add(mw.Timeout)
+	claims["exp"] = expire.Unix()
+	claims["orig_iat"] = mw.TimeFunc().Unix()
+
+	// sign token with active keyring key
+	token, err := keyring.Sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expire, nil
+}
+
+func LoginHandler(c *gin.Context) {
+	mw := InstanceJWT()
+
+	// authenticate user
+	data, err := mw.Authenticator(c)
+	if err != nil {
+		unauthorized(c, http.StatusUnauthorized, authErrorCode(c, http.StatusUnauthorized, err.Error()), mw.HTTPStatusMessageFunc(err, c))
+		return
+	}
+
+	// create token
+	token, expire, err := GenerateToken(data)
+	if err != nil {
+		logs.ForRequest(c, "AUTH").With(logs.Fields{"error": err.Error()}).Err("token creation error")
+		unauthorized(c, http.StatusInternalServerError, response.ErrInternal, mw.HTTPStatusMessageFunc(jwt.ErrFailedTokenCreation, c))
+		return
+	}
+
+	mw.LoginResponse(c, http.StatusOK, token, expire)
+}
+
+func RefreshHandler(c *gin.Context) {
+	mw := InstanceJWT()
+
+	// check token refresh window
+	claims, err := mw.CheckIfTokenExpire(c)
+	if err != nil {
+		unauthorized(c, http.StatusUnauthorized, authErrorCode(c, http.StatusUnauthorized, err.Error()), mw.HTTPStatusMessageFunc(err, c))
+		return
+	}
+
+	// copy claims and update expiration
+	newClaims := jwt.MapClaims{}
+	for key := range claims {
+		newClaims[key] = claims[key]
+	}
+	expire := mw.TimeFunc().Add(mw.Timeout)
+	newClaims["exp"] = expire.Unix()
+	newClaims["orig_iat"] = mw.TimeFunc().Unix()
+
+	// sign token with active keyring key
+	token, err := keyring.Sign(newClaims)
+	if err != nil {
+		logs.ForRequest(c, "AUTH").With(logs.Fields{"error": err.Error()}).Err("token refresh error")
+		unauthorized(c, http.StatusUnauthorized, authErrorCode(c, http.StatusUnauthorized, err.Error()), mw.HTTPStatusMessageFunc(err, c))
+		return
+	}
+
+	mw.RefreshResponse(c, http.StatusOK, token, expire)
+}
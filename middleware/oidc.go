@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/metrics"
+	"github.com/NethServer/ns-api-server/oidc"
+	"github.com/NethServer/ns-api-server/response"
+)
+
+func OIDCLogin(c *gin.Context) {
+	// compose provider authorization url
+	loginURL, err := oidc.LoginURL()
+	if err != nil {
+		logs.ForRequest(c, "OIDC").With(logs.Fields{"error": err.Error()}).Err("login request error")
+		response.Error(c, http.StatusServiceUnavailable, response.ErrUnavailable, "oidc provider unavailable", nil)
+		return
+	}
+
+	// redirect to provider
+	c.Redirect(http.StatusFound, loginURL)
+}
+
+func OIDCCallback(c *gin.Context) {
+	// check provider errors
+	if c.Query("error") != "" {
+		logs.ForRequest(c, "OIDC").With(logs.Fields{"ip": c.ClientIP(), "error": c.Query("error") + " " + c.Query("error_description")}).Info("authentication failed")
+		metrics.Logins.Inc("oidc", "failure")
+		unauthorized(c, http.StatusUnauthorized, response.ErrOIDCFailed, "oidc authentication failed: "+c.Query("error"))
+		return
+	}
+
+	// exchange code and map user
+	user, err := oidc.Exchange(c.Query("state"), c.Query("code"))
+	if err != nil {
+		logs.ForRequest(c, "OIDC").With(logs.Fields{"ip": c.ClientIP(), "error": err.Error()}).Info("authentication failed")
+		metrics.Logins.Inc("oidc", "failure")
+		unauthorized(c, http.StatusUnauthorized, response.ErrOIDCFailed, "oidc authentication failed")
+		return
+	}
+
+	// login ok action
+	logs.ForRequest(c, "OIDC").With(logs.Fields{"user": user.Username, "role": user.Role, "ip": c.ClientIP()}).Info("authentication success")
+	auditSession(c, "login", user.Username, user.Role, "oidc", http.StatusOK)
+	metrics.Logins.Inc("oidc", "success")
+
+	// create token as for password login
+	token, expire, err := GenerateToken(user)
+	if err != nil {
+		logs.ForRequest(c, "OIDC").With(logs.Fields{"error": err.Error()}).Err("token creation error")
+		unauthorized(c, http.StatusInternalServerError, response.ErrInternal, "oidc token creation failed")
+		return
+	}
+
+	// return token to the UI, if configured
+	if configuration.Get().OIDCPostLoginURL != "" {
+		registerLogin(c, token)
+
+		// with session cookie, token is not given to the UI
+		fragment := url.Values{}
+		data := tokenResponse(c, token, expire)
+		if data.Token != "" {
+			fragment.Add("token", data.Token)
+		}
+		if data.CSRFToken != "" {
+			fragment.Add("csrf_token", data.CSRFToken)
+		}
+		fragment.Add("expire", expire.Format(time.RFC3339))
+		c.Redirect(http.StatusFound, configuration.Get().OIDCPostLoginURL+"#"+fragment.Encode())
+		return
+	}
+
+	InstanceJWT().LoginResponse(c, http.StatusOK, token, expire)
+}
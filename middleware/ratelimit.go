@@ -0,0 +1,83 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/metrics"
+	"github.com/NethServer/ns-api-server/ratelimit"
+	"github.com/NethServer/ns-api-server/response"
+)
+
+// routes with strict limits, by path suffix
+var rateLimitGroups = map[string]string{
+	"/login":          "login",
+	"/oidc/login":     "login",
+	"/oidc/callback":  "login",
+	"/2fa/otp-verify": "otp",
+}
+
+func RateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		group := rateLimitGroup(c)
+		result := ratelimit.Allow(group, rateLimitClient(c))
+		if result.Limit.Requests == 0 {
+			c.Next()
+			return
+		}
+
+		// announce limit as in IETF RateLimit header fields draft
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit.Requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(int(result.Reset.Seconds())))
+		c.Header("RateLimit-Policy", strconv.Itoa(result.Limit.Requests)+";w="+strconv.Itoa(int(result.Limit.Period.Seconds())))
+
+		if !result.Allowed {
+			metrics.RateLimited.Inc(group, "limited")
+			logs.ForRequest(c, "HTTP").With(logs.Fields{"group": group, "client": rateLimitClient(c), "route": c.FullPath()}).Warning("rate limit exceeded")
+
+			c.Header("Retry-After", strconv.Itoa(int(result.RetryAfter.Seconds())))
+			response.Abort(c, http.StatusTooManyRequests, response.ErrRateLimited, "too many requests", nil)
+			return
+		}
+
+		metrics.RateLimited.Inc(group, "allowed")
+		c.Next()
+	}
+}
+
+func rateLimitGroup(c *gin.Context) string {
+	for suffix, group := range rateLimitGroups {
+		if strings.HasSuffix(c.FullPath(), suffix) {
+			return group
+		}
+	}
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		return "read"
+	}
+	return "write"
+}
+
+func rateLimitClient(c *gin.Context) string {
+	// authenticated users and api keys, else client address
+	if claims, ok := c.Value("JWT_PAYLOAD").(jwt.MapClaims); ok {
+		if id, ok := claims[identityKey].(string); ok && id != "" {
+			return id
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
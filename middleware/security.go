@@ -0,0 +1,46 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package middleware
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/configuration"
+)
+
+func SecurityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+
+		// common to API and static files
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "no-referrer")
+		header.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=(), payment=(), usb=()")
+		if configuration.Get().SecurityFrameOptions != "off" {
+			header.Set("X-Frame-Options", configuration.Get().SecurityFrameOptions)
+		}
+
+		// browsers must use https only, once seen on https
+		if c.Request.TLS != nil && configuration.Get().SecurityHSTSMaxAge > 0 {
+			header.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(configuration.Get().SecurityHSTSMaxAge.Seconds()))+"; includeSubDomains")
+		}
+
+		// content policy for the static UI, api documentation has its own inline scripts
+		path := c.Request.URL.Path
+		if path != "/api" && !strings.HasPrefix(path, "/api/") && path != "/metrics" && configuration.Get().SecurityCSP != "off" {
+			header.Set("Content-Security-Policy", configuration.Get().SecurityCSP)
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/response"
+)
+
+var csrfHeader = "X-CSRF-Token"
+
+func csrfCookieName() string {
+	return configuration.Get().SessionCookieName + "_csrf"
+}
+
+func tokenLookup() string {
+	// session cookie is read after the header
+	lookup := "header: Authorization"
+	if configuration.Get().SessionCookie {
+		lookup += ", cookie: " + configuration.Get().SessionCookieName
+	}
+
+	// tokens in urls end up in proxy logs and browser history, use tickets instead
+	if configuration.Get().JWTQueryLookup {
+		lookup += ", query: jwt"
+	}
+
+	return lookup
+}
+
+func sameSite() http.SameSite {
+	if configuration.Get().SessionCookieSameSite == "lax" {
+		return http.SameSiteLaxMode
+	}
+	return http.SameSiteStrictMode
+}
+
+// set session and csrf cookies, returning the csrf token
+func setSessionCookies(c *gin.Context, token string, expire time.Time) string {
+	random := make([]byte, 32)
+	_, _ = rand.Read(random)
+	csrf := hex.EncodeToString(random)
+
+	// token is not readable by scripts, csrf token must be
+	maxAge := int(time.Until(expire).Seconds())
+	c.SetSameSite(sameSite())
+	c.SetCookie(configuration.Get().SessionCookieName, token, maxAge, "/", configuration.Get().SessionCookieDomain, true, true)
+	c.SetCookie(csrfCookieName(), csrf, maxAge, "/", configuration.Get().SessionCookieDomain, true, false)
+
+	return csrf
+}
+
+func clearSessionCookies(c *gin.Context) {
+	c.SetSameSite(sameSite())
+	c.SetCookie(configuration.Get().SessionCookieName, "", -1, "/", configuration.Get().SessionCookieDomain, true, true)
+	c.SetCookie(csrfCookieName(), "", -1, "/", configuration.Get().SessionCookieDomain, true, false)
+}
+
+// token response, without token when it is sent as cookie
+func tokenResponse(c *gin.Context, token string, expire time.Time) response.Token {
+	if !configuration.Get().SessionCookie {
+		return response.Token{Token: token, Expire: expire}
+	}
+	return response.Token{Expire: expire, CSRFToken: setSessionCookies(c, token, expire)}
+}
+
+func CSRF() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// double submit, header must match cookie
+		if csrfRequired(c) {
+			expected, _ := c.Cookie(csrfCookieName())
+			given := c.GetHeader(csrfHeader)
+			if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(given)) != 1 {
+				logs.ForRequest(c, "AUTH").With(logs.Fields{"ip": c.ClientIP(), "path": c.Request.RequestURI}).Warning("csrf token invalid")
+				response.Abort(c, http.StatusForbidden, response.ErrCSRFInvalid, "csrf token invalid", nil)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+func csrfRequired(c *gin.Context) bool {
+	if !configuration.Get().SessionCookie {
+		return false
+	}
+
+	// safe methods don't change state
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || c.Request.Method == http.MethodOptions {
+		return false
+	}
+
+	// explicit credentials are not sent by browsers on their own
+	if c.GetHeader("Authorization") != "" || c.GetHeader(apiKeyHeader) != "" {
+		return false
+	}
+
+	session, _ := c.Cookie(configuration.Get().SessionCookieName)
+	return session != ""
+}
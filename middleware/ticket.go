@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package middleware
+
+import (
+	"net/http"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/response"
+	"github.com/NethServer/ns-api-server/tickets"
+)
+
+var ticketParameter = "ticket"
+var ticketContextKey = "TICKET"
+
+func Ticket() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// tickets are used only without other credentials
+		secret := c.Query(ticketParameter)
+		if secret == "" || c.GetHeader("Authorization") != "" || c.GetHeader(apiKeyHeader) != "" {
+			c.Next()
+			return
+		}
+
+		// only for downloads and event streams
+		if c.Request.Method != http.MethodGet {
+			unauthorized(c, http.StatusUnauthorized, response.ErrTicketInvalid, "tickets are valid only for GET requests")
+			return
+		}
+
+		// redeem ticket, valid once and only for its path
+		ticket, valid := tickets.Redeem(secret, c.Request.URL.Path)
+		if !valid {
+			logs.ForRequest(c, "AUTH").With(logs.Fields{"ip": c.ClientIP(), "path": c.Request.URL.Path}).Info("ticket authentication failed")
+			unauthorized(c, http.StatusUnauthorized, response.ErrTicketInvalid, "ticket invalid or expired")
+			return
+		}
+
+		c.Set(ticketContextKey, ticket)
+		c.Next()
+	}
+}
+
+func ticketHandler(c *gin.Context, ticket tickets.Ticket) {
+	// set claims of ticket owner
+	claims := jwt.MapClaims{}
+	for key, value := range ticket.Claims {
+		claims[key] = value
+	}
+	claims["auth"] = "ticket"
+
+	authorizeClaims(c, claims)
+}
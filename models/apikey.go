@@ -0,0 +1,19 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package models
+
+import "time"
+
+type APIKeyJSON struct {
+	Name     string      `json:"name" structs:"name" binding:"required"`
+	Scopes   []UBusScope `json:"scopes" structs:"scopes" binding:"required"`
+	Networks []string    `json:"networks" structs:"networks"`
+	Expire   *time.Time  `json:"expire" structs:"expire"`
+}
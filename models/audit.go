@@ -0,0 +1,27 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package models
+
+import "time"
+
+type AuditQuery struct {
+	User    string     `form:"user" structs:"user"`
+	From    *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00" structs:"from"`
+	To      *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00" structs:"to"`
+	Action  string     `form:"action" structs:"action"`
+	Object  string     `form:"object" structs:"object"`
+	Method  string     `form:"method" structs:"method"`
+	Result  string     `form:"result" structs:"result" binding:"omitempty,oneof=success failure"`
+	IP      string     `form:"ip" structs:"ip"`
+	Request string     `form:"request_id" structs:"request_id"`
+	Cursor  int64      `form:"cursor" structs:"cursor" binding:"min=0"`
+	Limit   int        `form:"limit" structs:"limit" binding:"min=0,max=1000"`
+	Export  string     `form:"export" structs:"export" binding:"omitempty,oneof=csv json"`
+}
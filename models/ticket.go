@@ -0,0 +1,14 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package models
+
+type TicketJSON struct {
+	Path string `json:"path" structs:"path" binding:"required" example:"/api/v1/audit"`
+}
@@ -14,3 +14,8 @@ type UBusCallJSON struct {
 	Method  string      `json:"method" structs:"method"`
 	Payload interface{} `json:"payload" structs:"payload"`
 }
+
+type UBusScope struct {
+	Path   string `json:"path" structs:"path"`
+	Method string `json:"method" structs:"method"`
+}
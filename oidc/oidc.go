@@ -0,0 +1,361 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/models"
+	"github.com/NethServer/ns-api-server/utils"
+)
+
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	ID      string `json:"kid"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+type pendingLogin struct {
+	Verifier string
+	Nonce    string
+	Expire   time.Time
+}
+
+var metadata *providerMetadata
+var publicKeys map[string]interface{}
+var pending = map[string]pendingLogin{}
+var mutex sync.Mutex
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// pending logins must be completed within this interval
+var loginTimeout = 10 * time.Minute
+
+// prefix of provider users not mapped to local users, to keep them apart
+var unmappedPrefix = "oidc."
+
+func Enabled() bool {
+	return configuration.Get().OIDCIssuer != ""
+}
+
+func randomString() string {
+	random := make([]byte, 32)
+	_, _ = rand.Read(random)
+	return base64.RawURLEncoding.EncodeToString(random)
+}
+
+func getJSON(URL string, result interface{}) error {
+	// execute request
+	resp, err := client.Get(URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// check response
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, URL)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func discover() (*providerMetadata, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// return cached metadata
+	if metadata != nil {
+		return metadata, nil
+	}
+
+	// read provider configuration
+	var discovered providerMetadata
+	issuer := strings.TrimSuffix(configuration.Get().OIDCIssuer, "/")
+	if err := getJSON(issuer+"/.well-known/openid-configuration", &discovered); err != nil {
+		return nil, errors.Wrap(err, "oidc discovery error")
+	}
+	if discovered.Issuer != configuration.Get().OIDCIssuer {
+		return nil, errors.New("oidc discovery issuer mismatch: " + discovered.Issuer)
+	}
+	metadata = &discovered
+
+	return metadata, nil
+}
+
+func fetchKeys(jwksURI string) error {
+	// read provider keys
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(jwksURI, &jwks); err != nil {
+		return errors.Wrap(err, "oidc jwks error")
+	}
+
+	// decode supported keys
+	keys := map[string]interface{}{}
+	for _, key := range jwks.Keys {
+		if publicKey := decodeKey(key); publicKey != nil {
+			keys[key.ID] = publicKey
+		}
+	}
+
+	mutex.Lock()
+	publicKeys = keys
+	mutex.Unlock()
+
+	return nil
+}
+
+func decodeKey(key jsonWebKey) interface{} {
+	decode := func(value string) *big.Int {
+		b, _ := base64.RawURLEncoding.DecodeString(value)
+		return new(big.Int).SetBytes(b)
+	}
+
+	switch key.KeyType {
+	case "RSA":
+		return &rsa.PublicKey{N: decode(key.N), E: int(decode(key.E).Int64())}
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		if curve, ok := curves[key.Curve]; ok {
+			return &ecdsa.PublicKey{Curve: curve, X: decode(key.X), Y: decode(key.Y)}
+		}
+	case "OKP":
+		if x, err := base64.RawURLEncoding.DecodeString(key.X); err == nil && key.Curve == "Ed25519" {
+			return ed25519.PublicKey(x)
+		}
+	}
+
+	return nil
+}
+
+func keyFunc(jwksURI string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		// symmetric algorithms are not allowed, provider keys are public
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+
+		// search key, refresh keys once if not found
+		for i := 0; i < 2; i++ {
+			mutex.Lock()
+			key, found := publicKeys[kid]
+			mutex.Unlock()
+			if found {
+				return key, nil
+			}
+			if err := fetchKeys(jwksURI); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, fmt.Errorf("unknown provider key: %v", kid)
+	}
+}
+
+func LoginURL() (string, error) {
+	// read provider configuration
+	provider, err := discover()
+	if err != nil {
+		return "", err
+	}
+
+	// create pkce verifier, nonce and state
+	state := randomString()
+	login := pendingLogin{
+		Verifier: randomString(),
+		Nonce:    randomString(),
+		Expire:   time.Now().Add(loginTimeout),
+	}
+	challenge := sha256.Sum256([]byte(login.Verifier))
+
+	// save pending login and purge expired ones
+	mutex.Lock()
+	for key, value := range pending {
+		if value.Expire.Before(time.Now()) {
+			delete(pending, key)
+		}
+	}
+	pending[state] = login
+	mutex.Unlock()
+
+	// compose authorization url
+	params := url.Values{}
+	params.Add("response_type", "code")
+	params.Add("client_id", configuration.Get().OIDCClientID)
+	params.Add("redirect_uri", configuration.Get().OIDCRedirectURL)
+	params.Add("scope", strings.Join(configuration.Get().OIDCScopes, " "))
+	params.Add("state", state)
+	params.Add("nonce", login.Nonce)
+	params.Add("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Add("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return provider.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+func Exchange(state string, code string) (*models.UserAuthorizations, error) {
+	// get pending login
+	mutex.Lock()
+	login, found := pending[state]
+	delete(pending, state)
+	mutex.Unlock()
+	if !found || login.Expire.Before(time.Now()) {
+		return nil, errors.New("oidc state invalid or expired")
+	}
+
+	// read provider configuration
+	provider, err := discover()
+	if err != nil {
+		return nil, err
+	}
+
+	// exchange code for tokens
+	params := url.Values{}
+	params.Add("grant_type", "authorization_code")
+	params.Add("code", code)
+	params.Add("redirect_uri", configuration.Get().OIDCRedirectURL)
+	params.Add("client_id", configuration.Get().OIDCClientID)
+	params.Add("code_verifier", login.Verifier)
+	if configuration.Get().OIDCClientSecret != "" {
+		params.Add("client_secret", configuration.Get().OIDCClientSecret)
+	}
+	resp, err := client.PostForm(provider.TokenEndpoint, params)
+	if err != nil {
+		return nil, errors.Wrap(err, "oidc token request error")
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	// parse token response
+	var tokens tokenResponse
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, errors.Wrap(err, "oidc token response malformed")
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("oidc token request failed with status %d: %s", resp.StatusCode, tokens.Error)
+	}
+
+	// verify id token
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(tokens.IDToken, claims, keyFunc(provider.JWKSURI)); err != nil {
+		return nil, errors.Wrap(err, "oidc id token invalid")
+	}
+	if !claims.VerifyIssuer(provider.Issuer, true) {
+		return nil, errors.New("oidc id token issuer mismatch")
+	}
+	if !claims.VerifyAudience(configuration.Get().OIDCClientID, true) {
+		return nil, errors.New("oidc id token audience mismatch")
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != login.Nonce {
+		return nil, errors.New("oidc id token nonce mismatch")
+	}
+
+	return mapUser(claims)
+}
+
+func mapUser(claims jwt.MapClaims) (*models.UserAuthorizations, error) {
+	// read username claim
+	name, _ := claims[configuration.Get().OIDCUsernameClaim].(string)
+	if name == "" {
+		return nil, errors.New("oidc id token has no " + configuration.Get().OIDCUsernameClaim + " claim")
+	}
+
+	// map to local user, others can't take the name of a local user
+	username := unmappedPrefix + name
+	for _, mapping := range configuration.Get().OIDCUserMapping {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) == 2 && parts[0] == name {
+			username = parts[1]
+			break
+		}
+	}
+
+	// username is used in file paths
+	if !utils.ValidUsername(username) {
+		return nil, errors.New("oidc user " + strconv.Quote(name) + " has an invalid username")
+	}
+
+	// read groups claim
+	var groups []string
+	switch value := claims[configuration.Get().OIDCGroupsClaim].(type) {
+	case string:
+		groups = []string{value}
+	case []interface{}:
+		for _, group := range value {
+			if name, ok := group.(string); ok {
+				groups = append(groups, name)
+			}
+		}
+	}
+
+	// map groups to role, in mapping order
+	role := ""
+	for _, mapping := range configuration.Get().OIDCRoleMapping {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) == 2 && utils.Contains(parts[0], groups) {
+			role = parts[1]
+			break
+		}
+	}
+	if role == "" {
+		role = configuration.Get().OIDCDefaultRole
+	}
+	if role == "" {
+		return nil, errors.New("oidc user " + username + " has no mapped role")
+	}
+
+	return &models.UserAuthorizations{
+		Username: username,
+		Role:     role,
+	}, nil
+}
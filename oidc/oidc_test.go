@@ -0,0 +1,308 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/NethServer/ns-api-server/configuration"
+)
+
+// in-process identity provider, serving discovery, token and jwks endpoints
+type provider struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	kid      string
+	mutex    sync.Mutex
+	codes    map[string]jwt.MapClaims
+	verifier map[string]string
+	keyFetch int
+}
+
+func newProvider(t *testing.T) *provider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &provider{key: key, kid: "key-1", codes: map[string]jwt.MapClaims{}, verifier: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(providerMetadata{
+			Issuer:                p.server.URL,
+			AuthorizationEndpoint: p.server.URL + "/authorize",
+			TokenEndpoint:         p.server.URL + "/token",
+			JWKSURI:               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.mutex.Lock()
+		p.keyFetch++
+		kid := p.kid
+		p.mutex.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {{
+			KeyType: "RSA",
+			ID:      kid,
+			N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		code := r.PostFormValue("code")
+		p.mutex.Lock()
+		claims, found := p.codes[code]
+		challenge := p.verifier[code]
+		delete(p.codes, code)
+		p.mutex.Unlock()
+
+		// check pkce verifier and client
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !found || base64.RawURLEncoding.EncodeToString(verifier[:]) != challenge ||
+			r.PostFormValue("client_id") != "ns-api" || r.PostFormValue("redirect_uri") != "https://fw.example.org/oidc/callback" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(tokenResponse{Error: "invalid_grant"})
+			return
+		}
+
+		token, _ := claims["token"].(string)
+		if token == "" {
+			delete(claims, "token")
+			token = p.sign(claims, jwt.SigningMethodRS256, p.key)
+		}
+		_ = json.NewEncoder(w).Encode(tokenResponse{IDToken: token})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+func (p *provider) sign(claims jwt.MapClaims, method jwt.SigningMethod, key interface{}) string {
+	token := jwt.NewWithClaims(method, claims)
+	p.mutex.Lock()
+	token.Header["kid"] = p.kid
+	p.mutex.Unlock()
+	signed, _ := token.SignedString(key)
+	return signed
+}
+
+// start a login and let the provider issue a code for the given claims
+func (p *provider) authorize(t *testing.T, claims func(nonce string) jwt.MapClaims) (string, string) {
+	t.Helper()
+	login, err := LoginURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(login)
+	if err != nil || !strings.HasPrefix(login, p.server.URL+"/authorize?") {
+		t.Fatalf("unexpected login url %v: %v", login, err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "ns-api" {
+		t.Fatalf("unexpected login parameters %v", query)
+	}
+
+	code := randomString()
+	issued := claims(query.Get("nonce"))
+	p.mutex.Lock()
+	p.codes[code] = issued
+	p.verifier[code] = query.Get("code_challenge")
+	p.mutex.Unlock()
+
+	return query.Get("state"), code
+}
+
+func setup(t *testing.T) *provider {
+	t.Helper()
+	p := newProvider(t)
+	configuration.Set(configuration.Configuration{
+		OIDCIssuer:        p.server.URL,
+		OIDCClientID:      "ns-api",
+		OIDCRedirectURL:   "https://fw.example.org/oidc/callback",
+		OIDCScopes:        []string{"openid", "groups"},
+		OIDCUsernameClaim: "sub",
+		OIDCGroupsClaim:   "groups",
+		OIDCUserMapping:   []string{"alice=root"},
+		OIDCRoleMapping:   []string{"auditors=auditor", "admins=admin"},
+	})
+	metadata = nil
+	publicKeys = nil
+	pending = map[string]pendingLogin{}
+	t.Cleanup(func() {
+		configuration.Set(configuration.Configuration{})
+		metadata = nil
+		publicKeys = nil
+		pending = map[string]pendingLogin{}
+	})
+	return p
+}
+
+func TestExchange(t *testing.T) {
+	p := setup(t)
+	claims := func(subject string, groups ...string) func(nonce string) jwt.MapClaims {
+		return func(nonce string) jwt.MapClaims {
+			return jwt.MapClaims{
+				"iss":    p.server.URL,
+				"aud":    "ns-api",
+				"sub":    subject,
+				"nonce":  nonce,
+				"groups": groups,
+				"exp":    time.Now().Add(time.Minute).Unix(),
+			}
+		}
+	}
+	with := func(base func(string) jwt.MapClaims, name string, value interface{}) func(string) jwt.MapClaims {
+		return func(nonce string) jwt.MapClaims {
+			result := base(nonce)
+			result[name] = value
+			return result
+		}
+	}
+
+	tests := []struct {
+		name     string
+		claims   func(nonce string) jwt.MapClaims
+		username string
+		role     string
+	}{
+		{name: "mapped user", claims: claims("alice", "admins"), username: "root", role: "admin"},
+		{name: "unmapped user is kept apart from local users", claims: claims("root", "auditors"), username: "oidc.root", role: "auditor"},
+		{name: "first mapped group wins", claims: claims("bob", "admins", "auditors"), username: "oidc.bob", role: "auditor"},
+		{name: "no mapped group", claims: claims("bob", "users")},
+		{name: "path traversal", claims: claims("../../etc", "admins")},
+		{name: "path separator", claims: claims("bob/..", "admins")},
+		{name: "empty subject", claims: claims("", "admins")},
+		{name: "nonce mismatch", claims: with(claims("bob", "admins"), "nonce", "other")},
+		{name: "audience mismatch", claims: with(claims("bob", "admins"), "aud", "other-client")},
+		{name: "issuer mismatch", claims: with(claims("bob", "admins"), "iss", "https://evil.example.org")},
+		{name: "expired token", claims: with(claims("bob", "admins"), "exp", time.Now().Add(-time.Minute).Unix())},
+		{
+			name: "hmac token signed with public key",
+			claims: func(nonce string) jwt.MapClaims {
+				token := p.sign(claims("bob", "admins")(nonce), jwt.SigningMethodHS256, p.key.PublicKey.N.Bytes())
+				return jwt.MapClaims{"token": token}
+			},
+		},
+		{
+			name: "token signed by another key",
+			claims: func(nonce string) jwt.MapClaims {
+				other, _ := rsa.GenerateKey(rand.Reader, 2048)
+				token := p.sign(claims("bob", "admins")(nonce), jwt.SigningMethodRS256, other)
+				return jwt.MapClaims{"token": token}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, code := p.authorize(t, test.claims)
+			user, err := Exchange(state, code)
+			if (err == nil) != (test.username != "") {
+				t.Fatalf("login accepted is %v: %v", err == nil, err)
+			}
+			if user != nil && (user.Username != test.username || user.Role != test.role) {
+				t.Errorf("user is %+v, expected %v with role %v", user, test.username, test.role)
+			}
+		})
+	}
+}
+
+func TestExchangeState(t *testing.T) {
+	p := setup(t)
+	claims := func(nonce string) jwt.MapClaims {
+		return jwt.MapClaims{"iss": p.server.URL, "aud": "ns-api", "sub": "alice", "nonce": nonce, "groups": "admins"}
+	}
+
+	// state can be used once
+	state, code := p.authorize(t, claims)
+	if _, err := Exchange(state, code); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Exchange(state, code); err == nil {
+		t.Error("state reused")
+	}
+
+	// unknown state
+	_, code = p.authorize(t, claims)
+	if _, err := Exchange("unknown", code); err == nil {
+		t.Error("unknown state accepted")
+	}
+
+	// expired state
+	state, code = p.authorize(t, claims)
+	mutex.Lock()
+	login := pending[state]
+	login.Expire = time.Now().Add(-time.Second)
+	pending[state] = login
+	mutex.Unlock()
+	if _, err := Exchange(state, code); err == nil {
+		t.Error("expired state accepted")
+	}
+
+	// code of another login fails pkce check
+	state, _ = p.authorize(t, claims)
+	_, other := p.authorize(t, claims)
+	if _, err := Exchange(state, other); err == nil {
+		t.Error("code of another login accepted")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	p := setup(t)
+	claims := func(nonce string) jwt.MapClaims {
+		return jwt.MapClaims{"iss": p.server.URL, "aud": "ns-api", "sub": "alice", "nonce": nonce, "groups": "admins"}
+	}
+
+	state, code := p.authorize(t, claims)
+	if _, err := Exchange(state, code); err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown key id triggers a single refresh of provider keys
+	p.mutex.Lock()
+	p.kid = "key-2"
+	fetched := p.keyFetch
+	p.mutex.Unlock()
+	state, code = p.authorize(t, claims)
+	if _, err := Exchange(state, code); err != nil {
+		t.Fatal(err)
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.keyFetch != fetched+1 {
+		t.Errorf("keys fetched %d times, expected once", p.keyFetch-fetched)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	p := setup(t)
+	config := *configuration.Get()
+	config.OIDCIssuer = p.server.URL + "/"
+	configuration.Set(config)
+
+	if _, err := LoginURL(); err == nil || !strings.Contains(err.Error(), "issuer mismatch") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
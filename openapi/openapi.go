@@ -0,0 +1,230 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package openapi
+
+import (
+	_ "embed"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/response"
+)
+
+//go:embed explorer.html
+var Explorer []byte
+
+// envelope of all JSON responses
+var envelopeName = componentName(reflect.TypeOf(response.Envelope{}))
+
+// documented api version
+var apiPrefix = "/api/v1"
+
+var pathParameter = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+var routes gin.RoutesInfo
+
+func Register(registered gin.RoutesInfo) {
+	routes = registered
+}
+
+func Spec(version string) Schema {
+	components := schemas{}
+	for _, value := range componentTypes {
+		components.of(value)
+	}
+
+	// describe envelope, with known error codes
+	components.of(response.Envelope{})
+	components[envelopeName]["properties"].(Schema)["error"] = Schema{
+		"type": "string", "enum": response.ErrorCodes,
+	}
+
+	// describe routes, in path order
+	sorted := make(gin.RoutesInfo, len(routes))
+	copy(sorted, routes)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Path == sorted[j].Path {
+			return sorted[i].Method < sorted[j].Method
+		}
+		return sorted[i].Path < sorted[j].Path
+	})
+
+	paths := Schema{}
+	for _, route := range sorted {
+		// unversioned api is an alias, not documented
+		if strings.HasPrefix(route.Path, "/api/") && !strings.HasPrefix(route.Path, apiPrefix+"/") {
+			continue
+		}
+		specPath, item := pathItem(route.Path)
+		if paths[specPath] == nil {
+			paths[specPath] = item
+		}
+		paths[specPath].(Schema)[strings.ToLower(route.Method)] = describe(route, components)
+	}
+
+	// add ubus methods, if enabled
+	if configuration.Get().OpenAPIUBusSchemas {
+		addUBusSchemas(paths, components)
+	}
+
+	// session cookie, if enabled
+	securitySchemes := Schema{
+		"bearerAuth": Schema{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+		"apiKey":     Schema{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+	}
+	security := []Schema{{"bearerAuth": []string{}}, {"apiKey": []string{}}}
+	if configuration.Get().SessionCookie {
+		securitySchemes["cookieAuth"] = Schema{
+			"type": "apiKey", "in": "cookie", "name": configuration.Get().SessionCookieName,
+			"description": "State changing requests must send the " + configuration.Get().SessionCookieName + "_csrf cookie value in X-CSRF-Token header.",
+		}
+		security = append(security, Schema{"cookieAuth": []string{}})
+	}
+
+	return Schema{
+		"openapi": "3.0.3",
+		"info": Schema{
+			"title":       "NethSecurity API Server",
+			"description": "REST API of NethSecurity, mostly a wrapper of ubus methods.",
+			"version":     version,
+			"license":     Schema{"name": "GPL-2.0-only"},
+		},
+		"servers": []Schema{{"url": apiPrefix}},
+		"paths":   paths,
+		"components": Schema{
+			"schemas":         components,
+			"securitySchemes": securitySchemes,
+			"parameters": Schema{
+				"requestID": Schema{
+					"name": "X-Request-ID", "in": "header", "required": false,
+					"description": "Request id, generated if missing",
+					"schema":      Schema{"type": "string", "pattern": "^[A-Za-z0-9._:-]{1,128}$"},
+				},
+			},
+		},
+		"security": security,
+	}
+}
+
+func pathItem(routePath string) (string, Schema) {
+	// routes outside /api are relative to server root
+	item := Schema{}
+	specPath := strings.TrimPrefix(routePath, apiPrefix)
+	if !strings.HasPrefix(routePath, apiPrefix+"/") {
+		specPath = routePath
+		item["servers"] = []Schema{{"url": "/"}}
+	}
+
+	// convert :param to {param}
+	specPath = pathParameter.ReplaceAllString(specPath, "{$1}")
+
+	return specPath, item
+}
+
+func describe(route gin.RouteInfo, components schemas) Schema {
+	doc, documented := operations[route.Method+" "+route.Path]
+	if !documented {
+		doc = operation{Tag: "other"}
+	}
+
+	op := Schema{
+		"summary":     doc.Summary,
+		"tags":        []string{doc.Tag},
+		"operationId": operationID(route),
+	}
+	if doc.Description != "" {
+		op["description"] = doc.Description
+	}
+	if doc.Public {
+		op["security"] = []Schema{}
+	}
+
+	// parameters
+	parameters := []Schema{{"$ref": "#/components/parameters/requestID"}}
+	for _, match := range pathParameter.FindAllStringSubmatch(route.Path, -1) {
+		parameters = append(parameters, Schema{"name": match[1], "in": "path", "required": true, "schema": stringSchema})
+	}
+	parameters = append(parameters, queryParameters(doc.Query)...)
+	op["parameters"] = parameters
+
+	// request body
+	if doc.Body != nil {
+		op["requestBody"] = Schema{
+			"required": true,
+			"content":  Schema{"application/json": Schema{"schema": components.of(doc.Body)}},
+		}
+	}
+
+	// success response
+	status := doc.Status
+	if status == 0 {
+		status = 200
+	}
+	responses := Schema{}
+	switch {
+	case status == 302:
+		responses["302"] = Schema{"description": "Redirect"}
+	case doc.Raw != nil:
+		contentType := doc.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		responses[strconv.Itoa(status)] = Schema{
+			"description": http.StatusText(status),
+			"content":     Schema{contentType: Schema{"schema": components.of(doc.Raw)}},
+		}
+	default:
+		responses[strconv.Itoa(status)] = envelopeResponse(status, doc.Data, components)
+	}
+
+	// error responses, authenticated routes can always be refused
+	codes := append([]int{}, doc.Errors...)
+	if !doc.Public {
+		codes = append(codes, 401, 403)
+	}
+	if strings.HasPrefix(route.Path, apiPrefix+"/") {
+		codes = append(codes, 429)
+	}
+	for _, code := range codes {
+		responses[strconv.Itoa(code)] = envelopeResponse(code, nil, components)
+	}
+	op["responses"] = responses
+
+	return op
+}
+
+func envelopeResponse(code int, data interface{}, components schemas) Schema {
+	schema := ref(envelopeName)
+	if data != nil {
+		schema = Schema{"allOf": []Schema{schema, {"properties": Schema{"data": components.of(data)}}}}
+	}
+	return Schema{
+		"description": http.StatusText(code),
+		"content":     Schema{"application/json": Schema{"schema": schema}},
+	}
+}
+
+func operationID(route gin.RouteInfo) string {
+	// like post_keys_rotate
+	id := strings.ToLower(route.Method)
+	for _, part := range strings.FieldsFunc(strings.TrimPrefix(route.Path, apiPrefix), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		id += "_" + part
+	}
+	return id
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package openapi
+
+import (
+	"github.com/NethServer/ns-api-server/apikeys"
+	"github.com/NethServer/ns-api-server/audit"
+	"github.com/NethServer/ns-api-server/keyring"
+	"github.com/NethServer/ns-api-server/models"
+	"github.com/NethServer/ns-api-server/response"
+)
+
+type operation struct {
+	Summary     string
+	Description string
+	Tag         string
+	Public      bool
+	Query       interface{} // struct with form tags
+	Body        interface{} // request body, as model or schema
+	Data        interface{} // data of success envelope
+	Status      int         // success status, default 200
+	Raw         interface{} // success response without envelope
+	ContentType string      // success content type, default JSON
+	Errors      []int
+}
+
+var stringSchema = Schema{"type": "string"}
+var objectSchema = Schema{"type": "object"}
+
+var readinessChecks = Schema{
+	"type": "object",
+	"additionalProperties": Schema{
+		"type": "object",
+		"properties": Schema{
+			"status": Schema{"type": "string", "enum": []string{"ok", "fail"}},
+			"error":  stringSchema,
+		},
+	},
+}
+
+// documented routes, by method and full path
+var operations = map[string]operation{
+	"POST /api/v1/login": {
+		Summary: "Login with username and password", Tag: "auth", Public: true,
+		Body: response.LoginRequestJWT{}, Data: response.Token{}, Errors: []int{401},
+	},
+	"POST /api/v1/logout": {
+		Summary: "Logout, invalidating the token", Tag: "auth",
+	},
+	"GET /api/v1/refresh": {
+		Summary: "Refresh the token", Tag: "auth", Data: response.Token{},
+	},
+	"GET /api/v1/oidc/login": {
+		Summary: "Start single sign-on", Description: "Redirects to the OpenID Connect provider.", Tag: "auth", Public: true,
+		Status: 302, Errors: []int{503},
+	},
+	"GET /api/v1/oidc/callback": {
+		Summary: "Complete single sign-on", Description: "Redirects to the post login URL, if configured.", Tag: "auth", Public: true,
+		Data: response.Token{}, Errors: []int{401, 500},
+	},
+	"GET /api/v1/.well-known/jwks.json": {
+		Summary: "Public keys used to sign tokens", Tag: "auth", Public: true, Raw: keyring.JWKS{},
+	},
+	"POST /api/v1/2fa/otp-verify": {
+		Summary: "Verify OTP code and enable token", Tag: "2fa", Public: true,
+		Body: models.OTPJson{}, Data: stringSchema, Errors: []int{400, 404, 500},
+	},
+	"GET /api/v1/2fa": {
+		Summary: "Get 2FA status of current user", Tag: "2fa", Data: Schema{"type": "boolean"},
+	},
+	"DELETE /api/v1/2fa": {
+		Summary: "Disable 2FA for current user", Tag: "2fa", Errors: []int{404, 500},
+	},
+	"GET /api/v1/2fa/qr-code": {
+		Summary: "Generate 2FA secret and QR code URL", Tag: "2fa",
+		Data: Schema{"type": "object", "properties": Schema{"url": stringSchema, "key": stringSchema}}, Errors: []int{400},
+	},
+	"POST /api/v1/tickets": {
+		Summary: "Create download ticket", Description: "The ticket authenticates one GET request to path, as ticket query parameter.", Tag: "auth",
+		Body: models.TicketJSON{}, Status: 201, Errors: []int{400},
+		Data: Schema{"type": "object", "properties": Schema{"ticket": stringSchema, "path": stringSchema, "expire": Schema{"type": "string", "format": "date-time"}}},
+	},
+	"POST /api/v1/ubus/call": {
+		Summary: "Call ubus method", Tag: "ubus",
+		Body: models.UBusCallJSON{}, Data: objectSchema, Errors: []int{400},
+	},
+	"GET /api/v1/keys": {
+		Summary: "List keyring keys", Tag: "keyring", Data: []keyring.Key{},
+	},
+	"POST /api/v1/keys/rotate": {
+		Summary: "Rotate signing key", Tag: "keyring", Data: keyring.Key{}, Errors: []int{500},
+	},
+	"GET /api/v1/api-keys": {
+		Summary: "List api keys", Tag: "api-keys", Data: []apikeys.APIKey{},
+	},
+	"POST /api/v1/api-keys": {
+		Summary: "Create api key", Description: "The secret key is returned only once.", Tag: "api-keys",
+		Body: models.APIKeyJSON{}, Status: 201, Errors: []int{400, 500},
+		Data: Schema{"type": "object", "properties": Schema{"key": stringSchema, "api_key": ref("apikeys.APIKey")}},
+	},
+	"DELETE /api/v1/api-keys/:id": {
+		Summary: "Delete api key", Tag: "api-keys", Errors: []int{404},
+	},
+	"GET /api/v1/audit": {
+		Summary: "Search audit entries", Description: "With export, all matching entries are returned as file attachment.", Tag: "audit",
+		Query: models.AuditQuery{}, Errors: []int{400, 500},
+		Data: Schema{"type": "object", "properties": Schema{
+			"entries":     Schema{"type": "array", "items": ref("audit.Entry")},
+			"next_cursor": Schema{"type": "integer", "format": "int64"},
+		}},
+	},
+	"GET /api/v1/health": {
+		Summary: "Liveness check", Tag: "health", Public: true,
+	},
+	"GET /api/v1/ready": {
+		Summary: "Readiness check", Tag: "health", Public: true, Data: readinessChecks, Errors: []int{503},
+	},
+	"GET /api/v1/diagnostics": {
+		Summary: "Version, runtime stats and configuration", Tag: "health", Data: objectSchema,
+	},
+	"GET /metrics": {
+		Summary: "Prometheus metrics", Description: "Allowed networks and token are configured on the server.", Tag: "health", Public: true,
+		ContentType: "text/plain", Raw: stringSchema, Errors: []int{401, 403},
+	},
+	"GET /api/v1/openapi.json": {
+		Summary: "This document", Tag: "docs", Public: true, Raw: objectSchema,
+	},
+	"GET /api/v1/docs": {
+		Summary: "API explorer", Tag: "docs", Public: true, ContentType: "text/html", Raw: stringSchema,
+	},
+}
+
+// types referenced by schemas above
+var componentTypes = []interface{}{apikeys.APIKey{}, audit.Entry{}}
@@ -0,0 +1,179 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package openapi
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Schema map[string]interface{}
+
+var timeType = reflect.TypeOf(time.Time{})
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// component schemas of named structs, filled while building the spec
+type schemas map[string]Schema
+
+func componentName(t reflect.Type) string {
+	return path.Base(t.PkgPath()) + "." + t.Name()
+}
+
+func ref(name string) Schema {
+	return Schema{"$ref": "#/components/schemas/" + name}
+}
+
+func (components schemas) of(value interface{}) Schema {
+	if value == nil {
+		return Schema{}
+	}
+	if schema, ok := value.(Schema); ok {
+		return schema
+	}
+	return components.schemaOf(reflect.TypeOf(value))
+}
+
+func (components schemas) schemaOf(t reflect.Type) Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return Schema{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return Schema{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return Schema{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": components.schemaOf(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": components.schemaOf(t.Elem())}
+	case reflect.Struct:
+		// named structs are components, described once
+		if t.Name() == "" {
+			return components.structSchema(t)
+		}
+		name := componentName(t)
+		if _, found := components[name]; !found {
+			components[name] = Schema{}
+			components[name] = components.structSchema(t)
+		}
+		return ref(name)
+	}
+
+	// interfaces can hold any value
+	return Schema{}
+}
+
+func (components schemas) structSchema(t reflect.Type) Schema {
+	properties := Schema{}
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		// use json name, skip ignored fields
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := components.schemaOf(field.Type)
+		if schema["$ref"] == nil {
+			constraints(schema, field.Tag)
+		}
+		properties[name] = schema
+
+		if strings.Contains(field.Tag.Get("binding"), "required") {
+			required = append(required, name)
+		}
+	}
+
+	schema := Schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func queryParameters(value interface{}) []Schema {
+	// describe struct fields with form tag as query parameters
+	var parameters []Schema
+	if value == nil {
+		return parameters
+	}
+
+	components := schemas{}
+	t := reflect.TypeOf(value)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("form"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		schema := components.schemaOf(field.Type)
+		constraints(schema, field.Tag)
+		parameters = append(parameters, Schema{
+			"name":     name,
+			"in":       "query",
+			"required": strings.Contains(field.Tag.Get("binding"), "required"),
+			"schema":   schema,
+		})
+	}
+
+	return parameters
+}
+
+func constraints(schema Schema, tag reflect.StructTag) {
+	// convert example and validation rules of binding tag
+	if example := tag.Get("example"); example != "" {
+		if number, err := strconv.Atoi(example); err == nil && schema["type"] == "integer" {
+			schema["example"] = number
+		} else {
+			schema["example"] = example
+		}
+	}
+	for _, rule := range strings.Split(tag.Get("binding"), ",") {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "oneof":
+			schema["enum"] = strings.Fields(parts[1])
+		case "min", "max":
+			if number, err := strconv.Atoi(parts[1]); err == nil {
+				schema[map[string]string{"min": "minimum", "max": "maximum"}[parts[0]]] = number
+			}
+		}
+	}
+}
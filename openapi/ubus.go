@@ -0,0 +1,159 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package openapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/metrics"
+)
+
+// ubus methods and their arguments, by object
+type ubusObjects map[string]map[string]map[string]string
+
+var ubusObjectLine = regexp.MustCompile(`^'([^']+)' @`)
+var ubusMethodLine = regexp.MustCompile(`^\s+"([^"]+)":(\{.*\})\s*$`)
+
+// introspection is cached, objects rarely change
+var ubusCache ubusObjects
+var ubusCacheTime time.Time
+var ubusCacheTimeout = time.Minute
+var ubusMutex sync.Mutex
+
+// argument types printed by ubus -v list
+var ubusTypes = map[string]Schema{
+	"Boolean": {"type": "boolean"},
+	"Integer": {"type": "integer"},
+	"Double":  {"type": "number"},
+	"String":  {"type": "string"},
+	"Array":   {"type": "array", "items": Schema{}},
+	"Table":   {"type": "object"},
+}
+
+func introspect() (ubusObjects, error) {
+	ubusMutex.Lock()
+	defer ubusMutex.Unlock()
+
+	if ubusCache != nil && time.Since(ubusCacheTime) < ubusCacheTimeout {
+		return ubusCache, nil
+	}
+
+	// list objects with method signatures
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	metrics.ExecsInFlight.Inc()
+	out, err := exec.CommandContext(ctx, "/bin/ubus", "-v", "list").Output()
+	metrics.ExecsInFlight.Dec()
+	if err != nil {
+		return nil, err
+	}
+
+	ubusCache = parseUBusList(out)
+	ubusCacheTime = time.Now()
+
+	return ubusCache, nil
+}
+
+func parseUBusList(out []byte) ubusObjects {
+	objects := ubusObjects{}
+	object := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := ubusObjectLine.FindStringSubmatch(line); match != nil {
+			object = match[1]
+			objects[object] = map[string]map[string]string{}
+			continue
+		}
+		if match := ubusMethodLine.FindStringSubmatch(line); match != nil && object != "" {
+			arguments := map[string]string{}
+			if err := json.Unmarshal([]byte(match[2]), &arguments); err == nil {
+				objects[object][match[1]] = arguments
+			}
+		}
+	}
+
+	return objects
+}
+
+func addUBusSchemas(paths Schema, components schemas) {
+	objects, err := introspect()
+	if err != nil {
+		logs.For("UBUS").With(logs.Fields{"error": err.Error()}).Warning("ubus introspection failed")
+		return
+	}
+
+	// describe each method call as a component
+	var calls []Schema
+	for _, object := range sortedNames(objects) {
+		for _, method := range sortedNames(objects[object]) {
+			properties := Schema{}
+			for argument, argumentType := range objects[object][method] {
+				schema, known := ubusTypes[argumentType]
+				if !known {
+					schema = Schema{}
+				}
+				properties[argument] = schema
+			}
+
+			name := "ubus." + object + "." + method
+			components[name] = Schema{
+				"type":     "object",
+				"title":    object + " " + method,
+				"required": []string{"path", "method"},
+				"properties": Schema{
+					"path":    Schema{"type": "string", "enum": []string{object}},
+					"method":  Schema{"type": "string", "enum": []string{method}},
+					"payload": Schema{"type": "object", "properties": properties},
+				},
+			}
+			calls = append(calls, ref(name))
+		}
+	}
+	if len(calls) == 0 {
+		return
+	}
+
+	// document ubus call body as one of known calls
+	if item, ok := paths["/ubus/call"].(Schema); ok {
+		if op, ok := item["post"].(Schema); ok {
+			op["requestBody"] = Schema{
+				"required": true,
+				"content":  Schema{"application/json": Schema{"schema": Schema{"oneOf": calls}}},
+			}
+		}
+	}
+}
+
+func sortedNames(values interface{}) []string {
+	var names []string
+	switch values := values.(type) {
+	case ubusObjects:
+		for name := range values {
+			names = append(names, name)
+		}
+	case map[string]map[string]string:
+		for name := range values {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
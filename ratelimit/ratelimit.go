@@ -0,0 +1,179 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/NethServer/ns-api-server/configuration"
+)
+
+// route groups with their own limits
+var groups = []string{"login", "otp", "read", "write"}
+
+// requests allowed in a period, also the burst size
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// result of a request, as announced in RateLimit headers
+type Result struct {
+	Allowed    bool
+	Limit      Limit
+	Remaining  int
+	Reset      time.Duration // until bucket is full again
+	RetryAfter time.Duration // until next request is allowed
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// strict limits for credentials guessing, relaxed for reads
+var defaults = map[string]Limit{
+	"login": {Requests: 10, Period: time.Minute},
+	"otp":   {Requests: 10, Period: time.Minute},
+	"read":  {Requests: 600, Period: time.Minute},
+	"write": {Requests: 120, Period: time.Minute},
+}
+
+var limits map[string]Limit
+var buckets = map[string]*bucket{}
+var lastSweep time.Time
+var mutex sync.Mutex
+
+func Init() error {
+	// start from defaults, override configured groups
+	parsed := map[string]Limit{}
+	for group, limit := range defaults {
+		parsed[group] = limit
+	}
+	for _, value := range configuration.Get().RateLimits {
+		group, limit, err := parseLimit(value)
+		if err != nil {
+			return err
+		}
+		parsed[group] = limit
+	}
+
+	mutex.Lock()
+	limits = parsed
+	buckets = map[string]*bucket{}
+	mutex.Unlock()
+
+	return nil
+}
+
+// parse <group>=<requests>/<period> or <group>=off, off is a zero limit
+func parseLimit(value string) (string, Limit, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || !known(parts[0]) {
+		return "", Limit{}, errors.New(value + " must be <group>=<requests>/<period>, with group in " + strings.Join(groups, ", "))
+	}
+	if parts[1] == "off" {
+		return parts[0], Limit{}, nil
+	}
+
+	rate := strings.SplitN(parts[1], "/", 2)
+	if len(rate) != 2 {
+		return "", Limit{}, errors.New(value + " must be <group>=<requests>/<period>, like login=10/1m")
+	}
+	requests, err := strconv.Atoi(rate[0])
+	if err != nil || requests < 1 {
+		return "", Limit{}, errors.New(value + " requests must be a positive number")
+	}
+	period, err := time.ParseDuration(rate[1])
+	if err != nil || period <= 0 {
+		return "", Limit{}, errors.New(value + " period must be a positive duration, like 1m")
+	}
+
+	return parts[0], Limit{Requests: requests, Period: period}, nil
+}
+
+func known(group string) bool {
+	for _, name := range groups {
+		if name == group {
+			return true
+		}
+	}
+	return false
+}
+
+func Allow(group string, client string) Result {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// unlimited group
+	limit := limits[group]
+	if limit.Requests == 0 {
+		return Result{Allowed: true}
+	}
+
+	now := time.Now()
+	sweep(now)
+
+	// refill bucket for elapsed time, up to its size
+	rate := float64(limit.Requests) / limit.Period.Seconds()
+	key := group + " " + client
+	b, exists := buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(limit.Requests), updated: now}
+		buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Requests), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+
+	result := Result{Limit: limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = seconds((float64(limit.Requests) - b.tokens) / rate)
+
+	return result
+}
+
+func sweep(now time.Time) {
+	// once a minute, forget clients whose bucket is full again
+	if now.Sub(lastSweep) < time.Minute {
+		return
+	}
+	lastSweep = now
+
+	for key, b := range buckets {
+		limit := limits[strings.SplitN(key, " ", 2)[0]]
+		if limit.Requests == 0 || now.Sub(b.updated) >= limit.Period {
+			delete(buckets, key)
+		}
+	}
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(math.Ceil(value)) * time.Second
+}
+
+// number of clients currently tracked
+func Clients() int {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return len(buckets)
+}
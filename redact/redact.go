@@ -0,0 +1,243 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/NethServer/ns-api-server/configuration"
+)
+
+// replacement of sensitive values
+var Mask = "XXX"
+
+type rule struct {
+	object string
+	method string
+	fields [][]string
+}
+
+// values detected as secrets, wherever they are
+var detectors = []*regexp.Regexp{
+	// PEM blocks, like certificates and private keys
+	regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]+-----.*?-----END [A-Z0-9 ]+-----`),
+	// authorization headers
+	regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/-]+=*`),
+	// jwt tokens
+	regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`),
+	// crypt password hashes, like $6$salt$hash or $2y$10$hash
+	regexp.MustCompile(`\$(1|2[abxy]?|5|6|y|argon2(id|i|d)?|pbkdf2[a-z0-9-]*)\$[./A-Za-z0-9$=,+-]{8,}`),
+	// ldap password hashes
+	regexp.MustCompile(`\{(SSHA|SHA|SMD5|MD5|CRYPT)\}[A-Za-z0-9+/=$.]+`),
+}
+
+// generic keys whose value is named by a sibling key, like {"option": "password", "value": "..."}
+var nameKeys = []string{"name", "key", "option", "field"}
+var valueKeys = []string{"value", "values", "data", "val"}
+
+var keyPatterns []*regexp.Regexp
+var textPattern *regexp.Regexp
+var rules []rule
+var mutex sync.RWMutex
+
+func Init() error {
+	// sensitive words match any part of keys
+	var patterns []*regexp.Regexp
+	var words []string
+	for _, word := range configuration.Get().SensitiveList {
+		if word = strings.TrimSpace(word); word != "" {
+			patterns = append(patterns, regexp.MustCompile(`(?i)`+regexp.QuoteMeta(word)))
+			words = append(words, regexp.QuoteMeta(word))
+		}
+	}
+	for _, expression := range configuration.Get().RedactKeyPatterns {
+		pattern, err := regexp.Compile(expression)
+		if err != nil {
+			return errors.Wrap(err, "invalid redact key pattern")
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	// key=value and "key": "value" pairs in plain text
+	var text *regexp.Regexp
+	if len(words) > 0 {
+		text = regexp.MustCompile(`(?i)([\w.-]*(?:` + strings.Join(words, "|") + `)[\w.-]*"?\s*[:=]\s*)("(?:[^"\\]|\\.)*"|[^\s&,;"{}\[\]]+)`)
+	}
+
+	// per ubus method rules, in <object>/<method>=<field>[+<field>...] format
+	var parsed []rule
+	for _, value := range configuration.Get().RedactRules {
+		parts := strings.SplitN(value, "=", 2)
+		target := strings.SplitN(parts[0], "/", 2)
+		if len(parts) != 2 || len(target) != 2 {
+			return errors.New("invalid redact rule " + value)
+		}
+		current := rule{object: target[0], method: target[1]}
+		for _, field := range strings.Split(parts[1], "+") {
+			current.fields = append(current.fields, strings.Split(field, "."))
+		}
+		parsed = append(parsed, current)
+	}
+
+	mutex.Lock()
+	keyPatterns = patterns
+	textPattern = text
+	rules = parsed
+	mutex.Unlock()
+
+	return nil
+}
+
+func sensitiveKey(key string) bool {
+	for _, pattern := range keyPatterns {
+		if pattern.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(value string, values []string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchField(field []string, current []string) bool {
+	if len(field) != len(current) {
+		return false
+	}
+	for i := range field {
+		if field[i] != "*" && field[i] != current[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func walk(value interface{}, current []string, sensitive bool, fields [][]string) interface{} {
+	// check per method rules
+	for _, field := range fields {
+		if matchField(field, current) {
+			sensitive = true
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		// check if value keys are named by a sensitive sibling
+		named := false
+		for _, nameKey := range nameKeys {
+			if name, ok := value[nameKey].(string); ok && sensitiveKey(name) {
+				named = true
+			}
+		}
+
+		result := map[string]interface{}{}
+		for key, item := range value {
+			keySensitive := sensitive || sensitiveKey(key) || (named && contains(key, valueKeys))
+			result[key] = walk(item, append(current[:len(current):len(current)], key), keySensitive, fields)
+		}
+		return result
+	case []interface{}:
+		// array items inherit sensitivity of their key
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = walk(item, append(current[:len(current):len(current)], strconv.Itoa(i)), sensitive, fields)
+		}
+		return result
+	case string:
+		if sensitive {
+			return Mask
+		}
+		return detect(value)
+	case nil:
+		return nil
+	default:
+		if sensitive {
+			return Mask
+		}
+		return value
+	}
+}
+
+func detect(value string) string {
+	for _, detector := range detectors {
+		value = detector.ReplaceAllString(value, Mask)
+	}
+	return value
+}
+
+func Value(value interface{}) interface{} {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return walk(value, nil, false, nil)
+}
+
+func UBus(object string, method string, payload interface{}) interface{} {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	// collect field rules of the called method
+	var fields [][]string
+	for _, current := range rules {
+		objectMatch, _ := path.Match(current.object, object)
+		methodMatch, _ := path.Match(current.method, method)
+		if objectMatch && methodMatch {
+			fields = append(fields, current.fields...)
+		}
+	}
+
+	return walk(payload, nil, false, fields)
+}
+
+func JSON(body []byte) []byte {
+	// keep numbers as they are
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		// not a json body, redact as text
+		return []byte(Text(string(body)))
+	}
+
+	redacted, _ := json.Marshal(Value(value))
+	return redacted
+}
+
+func Text(text string) string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	// mask values of sensitive keys, keeping quotes
+	if textPattern != nil {
+		text = textPattern.ReplaceAllStringFunc(text, func(match string) string {
+			parts := textPattern.FindStringSubmatch(match)
+			if strings.HasPrefix(parts[2], `"`) {
+				return parts[1] + `"` + Mask + `"`
+			}
+			return parts[1] + Mask
+		})
+	}
+
+	return detect(text)
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package response
+
+// stable error codes, clients can rely on them instead of messages
+type ErrorCode string
+
+const (
+	// generic errors
+	ErrBadRequest  ErrorCode = "bad_request"    // request fields malformed
+	ErrNotFound    ErrorCode = "not_found"      // route or resource not found
+	ErrInternal    ErrorCode = "internal_error" // unexpected server error
+	ErrUnavailable ErrorCode = "unavailable"    // server or dependency not available
+
+	// authentication and authorization
+	ErrUnauthorized         ErrorCode = "unauthorized"          // credentials missing or not valid
+	ErrAuthenticationFailed ErrorCode = "authentication_failed" // wrong username or password
+	ErrTokenExpired         ErrorCode = "token_expired"         // token expired, login again
+	ErrTokenInvalid         ErrorCode = "token_invalid"         // token malformed or with invalid signature
+	ErrForbidden            ErrorCode = "forbidden"             // credentials not allowed for the request
+	ErrAPIKeyInvalid        ErrorCode = "api_key_invalid"       // api key not valid, expired or used from a network not allowed
+	ErrOIDCFailed           ErrorCode = "oidc_failed"           // single sign-on refused by provider or not verified
+	ErrRateLimited          ErrorCode = "rate_limited"          // too many requests, retry after Retry-After seconds
+	ErrCSRFInvalid          ErrorCode = "csrf_invalid"          // X-CSRF-Token header missing or not matching session
+	ErrTicketInvalid        ErrorCode = "ticket_invalid"        // ticket unknown, expired, already used or for another path
+
+	// 2FA
+	ErrOTPInvalid      ErrorCode = "otp_invalid"       // OTP code not valid
+	ErrOTPNotFound     ErrorCode = "otp_not_found"     // user has no 2FA secret
+	ErrOTPUpdateFailed ErrorCode = "otp_update_failed" // 2FA status or secret not saved
+
+	// resources
+	ErrUBusCallFailed   ErrorCode = "ubus_call_failed"  // ubus method failed, details contain the error
+	ErrValidationFailed ErrorCode = "validation_failed" // request fields not valid, details contain the reason
+	ErrAPIKeyNotFound   ErrorCode = "api_key_not_found" // api key does not exist
+	ErrKeyringFailed    ErrorCode = "keyring_failed"    // keyring not saved
+	ErrAuditFailed      ErrorCode = "audit_failed"      // audit log not readable
+	ErrNotReady         ErrorCode = "not_ready"         // readiness checks failed, details contain the checks
+)
+
+// all error codes, for documentation
+var ErrorCodes = []ErrorCode{
+	ErrBadRequest, ErrNotFound, ErrInternal, ErrUnavailable,
+	ErrUnauthorized, ErrAuthenticationFailed, ErrTokenExpired, ErrTokenInvalid, ErrForbidden, ErrAPIKeyInvalid, ErrOIDCFailed, ErrRateLimited, ErrCSRFInvalid, ErrTicketInvalid,
+	ErrOTPInvalid, ErrOTPNotFound, ErrOTPUpdateFailed,
+	ErrUBusCallFailed, ErrValidationFailed, ErrAPIKeyNotFound, ErrKeyringFailed, ErrAuditFailed, ErrNotReady,
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/logs"
+)
+
+var certificate *tls.Certificate
+var certModTime time.Time
+var certMutex sync.Mutex
+
+func modTime() time.Time {
+	// use the newest change between certificate and key
+	certInfo, errCert := os.Stat(configuration.Get().TLSCertFile)
+	keyInfo, errKey := os.Stat(configuration.Get().TLSKeyFile)
+	if errCert != nil || errKey != nil {
+		return time.Time{}
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime()
+	}
+	return certInfo.ModTime()
+}
+
+func initCertificate() error {
+	// generate self-signed certificate when missing
+	if _, err := os.Stat(configuration.Get().TLSCertFile); os.IsNotExist(err) {
+		if errGen := generateCertificate(); errGen != nil {
+			return errGen
+		}
+	}
+
+	// load certificate
+	_, err := getCertificate(nil)
+	return err
+}
+
+func getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certMutex.Lock()
+	defer certMutex.Unlock()
+
+	// reload certificate only when files change
+	current := modTime()
+	if certificate != nil && current.Equal(certModTime) {
+		return certificate, nil
+	}
+	cert, err := tls.LoadX509KeyPair(configuration.Get().TLSCertFile, configuration.Get().TLSKeyFile)
+	if err != nil {
+		// keep serving previous certificate, files may be partially written
+		if certificate != nil {
+			logs.For("TLS").With(logs.Fields{"error": err.Error()}).Err("certificate reload error")
+			return certificate, nil
+		}
+		return nil, errors.Wrap(err, "certificate load error")
+	}
+	if certificate != nil {
+		logs.For("TLS").With(logs.Fields{"file": configuration.Get().TLSCertFile}).Info("certificate reloaded")
+	}
+	certificate = &cert
+	certModTime = current
+
+	return certificate, nil
+}
+
+func generateCertificate() error {
+	// generate private key
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return errors.Wrap(err, "certificate key generation error")
+	}
+
+	// use hostname and local addresses as names
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: hostname},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{hostname, "localhost"},
+	}
+	template.SerialNumber, _ = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	addresses, _ := net.InterfaceAddrs()
+	for _, address := range addresses {
+		if ipNet, ok := address.(*net.IPNet); ok {
+			template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+		}
+	}
+
+	// create self-signed certificate
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return errors.Wrap(err, "certificate creation error")
+	}
+	keyDer, _ := x509.MarshalPKCS8PrivateKey(privateKey)
+
+	// write certificate and key
+	for _, file := range []string{configuration.Get().TLSCertFile, configuration.Get().TLSKeyFile} {
+		if _, errD := os.Stat(filepath.Dir(file)); os.IsNotExist(errD) {
+			_ = os.MkdirAll(filepath.Dir(file), 0700)
+		}
+	}
+	if err := ioutil.WriteFile(configuration.Get().TLSKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return errors.Wrap(err, "certificate key write error")
+	}
+	if err := ioutil.WriteFile(configuration.Get().TLSCertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}), 0644); err != nil {
+		return errors.Wrap(err, "certificate write error")
+	}
+
+	logs.For("TLS").With(logs.Fields{"host": hostname}).Info("self-signed certificate generated")
+
+	return nil
+}
@@ -0,0 +1,196 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/utils"
+)
+
+// set when listeners are passed to a new process
+var handedOver bool
+
+// new process must be ready within this interval, or it is stopped
+var handoverTimeout = time.Minute
+
+// variables of socket activation, valid only for the process started by the service manager
+var activationVariables = []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"}
+
+func handover() (<-chan error, error) {
+	// duplicate listener descriptors, passed from fd 3
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, listener := range listeners {
+		fileListener, ok := listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, errors.New("listener " + listener.Addr().String() + " can not be passed")
+		}
+		file, err := fileListener.File()
+		if err != nil {
+			return nil, errors.Wrap(err, "listener descriptor error")
+		}
+		files = append(files, file)
+	}
+
+	// new process writes to this pipe when it serves all listeners, passed after them
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "handover pipe error")
+	}
+	count := len(files)
+	files = append(files, readyWriter)
+
+	// start new binary with same arguments and environment
+	executable, err := os.Executable()
+	if err != nil {
+		ready.Close()
+		return nil, errors.Wrap(err, "executable path error")
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = handoverEnv(os.Environ(), count, configuration.ActivatedSockets())
+	cmd.ExtraFiles = files
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		ready.Close()
+		return nil, errors.Wrap(err, "new process start error")
+	}
+
+	logs.For("SERVER").With(logs.Fields{"pid": cmd.Process.Pid}).Info("listeners passed to new process")
+
+	exited := make(chan struct{})
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			logs.For("SERVER").With(logs.Fields{"pid": cmd.Process.Pid, "error": err.Error()}).Err("new process exited")
+		}
+		close(exited)
+	}()
+
+	// wait for new process, stop it if it is not ready in time
+	result := make(chan error, 1)
+	go func() {
+		err := waitReady(ready, handoverTimeout)
+		if err != nil {
+			_ = cmd.Process.Kill()
+			<-exited
+		}
+		result <- err
+	}()
+
+	return result, nil
+}
+
+func handoverEnv(environ []string, count int, activated int) []string {
+	// socket activation and previous handover variables don't apply to new process
+	var env []string
+	for _, variable := range environ {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if !utils.Contains(name, activationVariables) && !strings.HasPrefix(name, "HANDOVER_") {
+			env = append(env, variable)
+		}
+	}
+
+	env = append(env, "HANDOVER_FDS="+strconv.Itoa(count), "HANDOVER_READY_FD="+strconv.Itoa(3+count))
+	if activated > 0 {
+		env = append(env, "HANDOVER_ACTIVATED_FDS="+strconv.Itoa(activated))
+	}
+
+	return env
+}
+
+func waitReady(ready *os.File, timeout time.Duration) error {
+	defer ready.Close()
+
+	// pipe is closed without data if new process exits
+	_ = ready.SetReadDeadline(time.Now().Add(timeout))
+	if _, err := ready.Read(make([]byte, 1)); err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return errors.New("new process not ready after " + timeout.String())
+		}
+		return errors.New("new process exited before ready")
+	}
+
+	return nil
+}
+
+func inheritedListeners() ([]net.Listener, error) {
+	if os.Getenv("HANDOVER_FDS") == "" {
+		return nil, nil
+	}
+
+	// listeners are passed in configuration order
+	count, _ := strconv.Atoi(os.Getenv("HANDOVER_FDS"))
+	expected := len(configuration.Get().Listeners)
+	if configuration.Get().TLSRedirect != "" {
+		expected++
+	}
+	if count != expected {
+		return nil, errors.New("handover listeners do not match configuration")
+	}
+
+	var inherited []net.Listener
+	for fd := 3; fd < 3+count; fd++ {
+		file := os.NewFile(uintptr(fd), "handover:"+strconv.Itoa(fd))
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "handover listener error")
+		}
+		inherited = append(inherited, listener)
+	}
+
+	return inherited, nil
+}
+
+func handoverCompleted() error {
+	if os.Getenv("HANDOVER_FDS") == "" {
+		return nil
+	}
+	fd, _ := strconv.Atoi(os.Getenv("HANDOVER_READY_FD"))
+	os.Unsetenv("HANDOVER_FDS")
+	os.Unsetenv("HANDOVER_READY_FD")
+
+	// tell previous process to stop, pending requests are drained there
+	logs.For("SERVER").With(logs.Fields{"pid": os.Getppid()}).Info("listeners taken over from previous process")
+	if fd < 3 {
+		return errors.New("handover ready pipe not passed")
+	}
+	ready := os.NewFile(uintptr(fd), "handover:ready")
+	defer ready.Close()
+	_, err := ready.Write([]byte{1})
+
+	return err
+}
+
+func writePidFile() {
+	if configuration.Get().PidFile == "" {
+		return
+	}
+
+	// write pid file, used by service manager to follow handovers
+	if err := ioutil.WriteFile(configuration.Get().PidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		logs.For("SERVER").With(logs.Fields{"error": err.Error()}).Err("pid file write error")
+	}
+}
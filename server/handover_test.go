@@ -0,0 +1,133 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package server
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// test binary is also the new process started by handover tests
+	switch os.Getenv("HANDOVER_TEST") {
+	case "ready":
+		if os.Getenv("LISTEN_FDS") != "" || os.Getenv("HANDOVER_FDS") != "1" || os.Getenv("HANDOVER_ACTIVATED_FDS") != "" {
+			os.Exit(3)
+		}
+		listener, err := net.FileListener(os.NewFile(3, "handover:3"))
+		if err != nil {
+			os.Exit(4)
+		}
+		listener.Close()
+		if err := handoverCompleted(); err != nil {
+			os.Exit(2)
+		}
+		os.Exit(0)
+	case "fail":
+		os.Exit(1)
+	case "hang":
+		time.Sleep(time.Minute)
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func setenv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for name, value := range env {
+		previous, found := os.LookupEnv(name)
+		os.Setenv(name, value)
+		t.Cleanup(func() {
+			if found {
+				os.Setenv(name, previous)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+func TestHandover(t *testing.T) {
+	tests := []struct {
+		mode    string
+		timeout time.Duration
+		err     string
+	}{
+		{mode: "ready", timeout: time.Minute},
+		{mode: "fail", timeout: time.Minute, err: "exited before ready"},
+		{mode: "hang", timeout: 500 * time.Millisecond, err: "not ready"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			listeners = []net.Listener{listener}
+			handoverTimeout = test.timeout
+			t.Cleanup(func() {
+				listener.Close()
+				listeners = nil
+				handoverTimeout = time.Minute
+			})
+
+			// variables of socket activation of this process are not passed
+			setenv(t, map[string]string{"HANDOVER_TEST": test.mode, "LISTEN_PID": "1", "LISTEN_FDS": "1"})
+
+			result, err := handover()
+			if err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case err := <-result:
+				if test.err == "" && err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+					t.Errorf("error is %v, expected %q", err, test.err)
+				}
+			case <-time.After(30 * time.Second):
+				t.Fatal("handover result not received")
+			}
+		})
+	}
+}
+
+func TestHandoverEnv(t *testing.T) {
+	environ := []string{
+		"PATH=/usr/bin",
+		"LISTEN_ADDRESS=127.0.0.1:8080",
+		"LISTEN_PID=100",
+		"LISTEN_FDS=2",
+		"LISTEN_FDNAMES=api:metrics",
+		"HANDOVER_ACTIVATED_FDS=2",
+		"HANDOVER_READY_FD=5",
+	}
+
+	tests := []struct {
+		activated int
+		env       []string
+	}{
+		{activated: 0, env: []string{"PATH=/usr/bin", "LISTEN_ADDRESS=127.0.0.1:8080", "HANDOVER_FDS=3", "HANDOVER_READY_FD=6"}},
+		{activated: 2, env: []string{"PATH=/usr/bin", "LISTEN_ADDRESS=127.0.0.1:8080", "HANDOVER_FDS=3", "HANDOVER_READY_FD=6", "HANDOVER_ACTIVATED_FDS=2"}},
+	}
+
+	for _, test := range tests {
+		env := handoverEnv(environ, 3, test.activated)
+		if strings.Join(env, " ") != strings.Join(test.env, " ") {
+			t.Errorf("environment is %v, expected %v", env, test.env)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package server
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/NethServer/ns-api-server/configuration"
+)
+
+func listen(config configuration.Listener) (net.Listener, error) {
+	switch config.Network {
+	case "unix":
+		return listenUnix(config)
+	case "fd":
+		return listenFD(config.Address)
+	default:
+		return net.Listen("tcp", config.Address)
+	}
+}
+
+func listenUnix(config configuration.Listener) (net.Listener, error) {
+	// remove stale socket left by a previous run
+	if info, err := os.Stat(config.Address); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(config.Address)
+	}
+
+	listener, err := net.Listen("unix", config.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	// set socket permissions
+	if config.Mode != 0 {
+		if err := os.Chmod(config.Address, config.Mode); err != nil {
+			listener.Close()
+			return nil, errors.Wrap(err, "unix socket chmod error")
+		}
+	}
+
+	// set socket owner, in user[:group] format
+	if config.Owner != "" {
+		uid, gid, err := lookupOwner(config.Owner)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		if err := os.Chown(config.Address, uid, gid); err != nil {
+			listener.Close()
+			return nil, errors.Wrap(err, "unix socket chown error")
+		}
+	}
+
+	return listener, nil
+}
+
+func lookupOwner(owner string) (int, int, error) {
+	parts := strings.SplitN(owner, ":", 2)
+
+	// resolve user, group defaults to user primary group
+	owningUser, err := user.Lookup(parts[0])
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "unix socket owner error")
+	}
+	uid, _ := strconv.Atoi(owningUser.Uid)
+	gid, _ := strconv.Atoi(owningUser.Gid)
+
+	if len(parts) == 2 {
+		group, err := user.LookupGroup(parts[1])
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "unix socket group error")
+		}
+		gid, _ = strconv.Atoi(group.Gid)
+	}
+
+	return uid, gid, nil
+}
+
+func listenFD(address string) (net.Listener, error) {
+	// sockets can be referenced by number or by name
+	fd, err := strconv.Atoi(address)
+	if err != nil {
+		fd = -1
+		for i, name := range strings.Split(os.Getenv("LISTEN_FDNAMES"), ":") {
+			if name == address {
+				fd = 3 + i
+				break
+			}
+		}
+	}
+
+	// check socket is passed to this process
+	count, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) || fd < 3 || fd >= 3+count {
+		return nil, errors.New("socket " + address + " not passed by service manager")
+	}
+
+	// net.FileListener duplicates descriptor, original one can be closed
+	file := os.NewFile(uintptr(fd), "fd:"+address)
+	defer file.Close()
+
+	return net.FileListener(file)
+}
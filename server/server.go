@@ -0,0 +1,258 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/logs"
+	"github.com/NethServer/ns-api-server/response"
+	"github.com/NethServer/ns-api-server/utils"
+)
+
+var servers []*http.Server
+var listeners []net.Listener
+
+func Run(handler http.Handler) error {
+	// init tls, shared by all tls listeners
+	var tlsConfig *tls.Config
+	if configuration.Get().TLS {
+		var err error
+		if tlsConfig, err = TLSConfig(); err != nil {
+			return err
+		}
+	}
+
+	// open all listeners before serving, to fail early
+	if err := openListeners(); err != nil {
+		return err
+	}
+
+	// serve each listener with its own routes
+	errs := make(chan error, len(listeners))
+	for i, config := range configuration.Get().Listeners {
+		srv := &http.Server{Handler: routes(handler, config), TLSConfig: tlsConfig}
+		listener := listeners[i]
+		secure := config.TLS
+		servers = append(servers, srv)
+
+		logs.For("SERVER").With(logs.Fields{"address": config.Network + ":" + config.Address, "routes": strings.Join(config.Routes, ",")}).Info("listening")
+
+		go func() {
+			// certificate is provided by tls configuration
+			if secure {
+				errs <- srv.ServeTLS(listener, "", "")
+			} else {
+				errs <- srv.Serve(listener)
+			}
+		}()
+	}
+
+	// redirect plain http to https
+	if configuration.Get().TLSRedirect != "" {
+		srv := redirectServer()
+		listener := listeners[len(listeners)-1]
+		servers = append(servers, srv)
+
+		go func() {
+			if err := srv.Serve(listener); err != http.ErrServerClosed {
+				logs.For("TLS").With(logs.Fields{"error": err.Error()}).Err("redirect listener error")
+			}
+		}()
+	}
+
+	// ready to serve, tell previous process to stop
+	if err := handoverCompleted(); err != nil {
+		logs.For("SERVER").With(logs.Fields{"error": err.Error()}).Err("handover error")
+	}
+	writePidFile()
+
+	// wait for signals
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR2, syscall.SIGHUP)
+	var handoverResult <-chan error
+	for {
+		select {
+		case err := <-errs:
+			// stop at first listener failure
+			return err
+		case err := <-handoverResult:
+			// new process is serving, or keep serving if it failed
+			handoverResult = nil
+			if err != nil {
+				handedOver = false
+				logs.For("SERVER").With(logs.Fields{"error": err.Error()}).Err("handover failed, keep serving")
+				continue
+			}
+			return shutdown()
+		case sig := <-signals:
+			switch sig {
+			case syscall.SIGHUP:
+				if err := configuration.Reload(); err != nil {
+					logs.For("ENV").With(logs.Fields{"error": strings.Replace(err.Error(), "\n", ", ", -1)}).Err("configuration reload error, keeping current one")
+				}
+			case syscall.SIGUSR2:
+				if handoverResult != nil {
+					logs.For("SERVER").Warning("handover already in progress")
+					continue
+				}
+				result, err := handover()
+				if err != nil {
+					logs.For("SERVER").With(logs.Fields{"error": err.Error()}).Err("handover error")
+					continue
+				}
+				handoverResult = result
+				handedOver = true
+			default:
+				return shutdown()
+			}
+		}
+	}
+}
+
+func openListeners() error {
+	// listeners are inherited from previous process on handover
+	if inherited, err := inheritedListeners(); inherited != nil || err != nil {
+		listeners = inherited
+		return err
+	}
+
+	for _, config := range configuration.Get().Listeners {
+		listener, err := listen(config)
+		if err != nil {
+			closeListeners()
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	// redirect listener is always the last one
+	if configuration.Get().TLSRedirect != "" {
+		listener, err := net.Listen("tcp", configuration.Get().TLSRedirect)
+		if err != nil {
+			closeListeners()
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return nil
+}
+
+func closeListeners() {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	listeners = nil
+}
+
+func shutdown() error {
+	logs.For("SERVER").Info("shutting down, waiting for pending requests")
+
+	// remove unix sockets, unless they are used by the new process
+	for _, listener := range listeners {
+		if unixListener, ok := listener.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(!handedOver)
+		}
+	}
+
+	// stop accepting connections and drain pending requests
+	ctx, cancel := context.WithTimeout(context.Background(), configuration.Get().ShutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+
+			// close remaining connections when drain period expires
+			if err := srv.Shutdown(ctx); err != nil {
+				logs.For("SERVER").With(logs.Fields{"error": err.Error()}).Err("shutdown drain period expired")
+				srv.Close()
+			}
+		}(srv)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// address of unix socket peers: not loopback, so they are neither trusted proxies nor allowed by default
+const unixPeerAddress = "0.0.0.0:0"
+
+func routes(handler http.Handler, config configuration.Listener) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// unix socket peers have no address, mark them so they are not taken as loopback clients
+		if config.Network == "unix" {
+			r.RemoteAddr = unixPeerAddress
+		}
+
+		// check route group is served by this listener
+		group := "static"
+		if r.URL.Path == "/api" || strings.HasPrefix(r.URL.Path, "/api/") {
+			group = "api"
+		}
+		if r.URL.Path == "/metrics" {
+			group = "metrics"
+		}
+		if !utils.Contains(group, config.Routes) {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(response.Envelope{
+				Code:    http.StatusNotFound,
+				Error:   response.ErrNotFound,
+				Message: "API not found",
+			})
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func redirectPort() string {
+	// use port of first tls listener on tcp
+	for _, config := range configuration.Get().Listeners {
+		if config.TLS && config.Network == "tcp" {
+			_, port, _ := net.SplitHostPort(config.Address)
+			return port
+		}
+	}
+	return "443"
+}
+
+func redirectServer() *http.Server {
+	port := redirectPort()
+
+	return &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// keep requested host, with https port
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host
+			}
+			if port != "443" {
+				host = net.JoinHostPort(host, port)
+			}
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+		}),
+	}
+}
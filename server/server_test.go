@@ -0,0 +1,84 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/utils"
+)
+
+func TestRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	if err := router.SetTrustedProxies([]string{"127.0.0.1", "::1"}); err != nil {
+		t.Fatal(err)
+	}
+	router.Use(func(c *gin.Context) {
+		c.String(http.StatusOK, c.ClientIP())
+	})
+
+	tests := []struct {
+		name     string
+		listener configuration.Listener
+		path     string
+		remote   string
+		status   int
+		clientIP string
+	}{
+		{
+			name:     "tcp client",
+			listener: configuration.Listener{Network: "tcp", Routes: []string{"api"}},
+			path:     "/api/v1/login", remote: "192.168.1.10:4000", status: http.StatusOK, clientIP: "192.168.1.10",
+		},
+		{
+			name:     "loopback proxy sets client address",
+			listener: configuration.Listener{Network: "tcp", Routes: []string{"api"}},
+			path:     "/api/v1/login", remote: "127.0.0.1:4000", status: http.StatusOK, clientIP: "10.0.0.1",
+		},
+		{
+			name:     "unix socket peer is not a trusted proxy",
+			listener: configuration.Listener{Network: "unix", Routes: []string{"api"}},
+			path:     "/api/v1/login", remote: "@", status: http.StatusOK, clientIP: "0.0.0.0",
+		},
+		{
+			name:     "route group not served",
+			listener: configuration.Listener{Network: "tcp", Routes: []string{"static"}},
+			path:     "/metrics", remote: "127.0.0.1:4000", status: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", test.path, nil)
+			request.RemoteAddr = test.remote
+			request.Header.Set("X-Forwarded-For", "10.0.0.1")
+			recorder := httptest.NewRecorder()
+
+			routes(router, test.listener).ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Fatalf("status is %d, expected %d", recorder.Code, test.status)
+			}
+			if test.clientIP != "" && recorder.Body.String() != test.clientIP {
+				t.Errorf("client address is %q, expected %q", recorder.Body.String(), test.clientIP)
+			}
+		})
+	}
+
+	// unix socket peers are not allowed by loopback networks
+	if utils.AllowedIP([]string{"127.0.0.0/8", "::1"}, "0.0.0.0") {
+		t.Error("unix socket peer allowed as loopback client")
+	}
+}
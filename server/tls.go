@@ -0,0 +1,171 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/NethServer/ns-api-server/configuration"
+	"github.com/NethServer/ns-api-server/logs"
+)
+
+var crl *x509.RevocationList
+var crlModTime time.Time
+var crlExpiredLogged bool
+var crlMutex sync.Mutex
+
+func TLSConfig() (*tls.Config, error) {
+	// load or generate server certificate
+	if err := initCertificate(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+	}
+	if configuration.Get().TLSMinVersion == "1.3" {
+		tlsConfig.MinVersion = tls.VersionTLS13
+	}
+
+	// restrict cipher suites, applies to TLS 1.2 only
+	for _, name := range configuration.Get().TLSCiphers {
+		id, found := cipherSuite(strings.TrimSpace(name))
+		if !found {
+			return nil, errors.New("unknown or insecure cipher suite " + name)
+		}
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+	}
+
+	// request client certificates
+	if configuration.Get().TLSClientCAFile != "" {
+		caB, err := ioutil.ReadFile(configuration.Get().TLSClientCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "client ca file read error")
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(caB) {
+			return nil, errors.New("client ca file has no valid certificates")
+		}
+
+		// clients without certificate can still use other authentication methods
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if configuration.Get().TLSClientAuth == "require" {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+
+		// check revocation list
+		if configuration.Get().TLSClientCRLFile != "" {
+			list, err := revocationList()
+			if err != nil {
+				return nil, err
+			}
+			crlExpired(list)
+			tlsConfig.VerifyPeerCertificate = verifyRevocation
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+func cipherSuite(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
+
+func revocationList() (*x509.RevocationList, error) {
+	crlMutex.Lock()
+	defer crlMutex.Unlock()
+
+	// reload list only when file changes
+	info, err := os.Stat(configuration.Get().TLSClientCRLFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "client crl file read error")
+	}
+	if crl != nil && info.ModTime().Equal(crlModTime) {
+		return crl, nil
+	}
+
+	// parse list, in PEM or DER format
+	crlB, err := ioutil.ReadFile(configuration.Get().TLSClientCRLFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "client crl file read error")
+	}
+	if block, _ := pem.Decode(crlB); block != nil && block.Type == "X509 CRL" {
+		crlB = block.Bytes
+	}
+	list, err := x509.ParseRevocationList(crlB)
+	if err != nil {
+		return nil, errors.Wrap(err, "client crl file malformed")
+	}
+	crl = list
+	crlModTime = info.ModTime()
+	crlExpiredLogged = false
+
+	return crl, nil
+}
+
+func crlExpired(list *x509.RevocationList) bool {
+	if list.NextUpdate.IsZero() || time.Now().Before(list.NextUpdate) {
+		return false
+	}
+
+	// warn once for each list
+	crlMutex.Lock()
+	defer crlMutex.Unlock()
+	if !crlExpiredLogged {
+		crlExpiredLogged = true
+		logs.For("TLS").With(logs.Fields{"file": configuration.Get().TLSClientCRLFile, "next_update": list.NextUpdate.Format(time.RFC3339)}).Warning("client crl file expired, client certificates are refused")
+	}
+
+	return true
+}
+
+func verifyRevocation(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	// read current revocation list
+	list, err := revocationList()
+	if err != nil {
+		return err
+	}
+
+	// stale list may miss recent revocations
+	if crlExpired(list) {
+		return errors.New("client crl file expired on " + list.NextUpdate.Format(time.RFC3339))
+	}
+
+	// check every certificate signed by the list issuer
+	for _, chain := range verifiedChains {
+		for i, cert := range chain {
+			if i+1 >= len(chain) || list.CheckSignatureFrom(chain[i+1]) != nil {
+				continue
+			}
+			for _, revoked := range list.RevokedCertificateEntries {
+				if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return errors.New("client certificate " + cert.Subject.String() + " is revoked")
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NethServer/ns-api-server/configuration"
+)
+
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newAuthority(t *testing.T, name string) authority {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authority{cert: cert, key: key}
+}
+
+func (a authority) issue(t *testing.T, serial int64) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func (a authority) revocationList(t *testing.T, nextUpdate time.Time, serials ...int64) []byte {
+	t.Helper()
+	var revoked []x509.RevocationListEntry
+	for _, serial := range serials {
+		revoked = append(revoked, x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now().Add(-time.Hour),
+		NextUpdate:                nextUpdate,
+		RevokedCertificateEntries: revoked,
+	}, a.cert, a.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func setupCRL(t *testing.T, content []byte) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "crl.pem")
+	if err := ioutil.WriteFile(file, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	configuration.Set(configuration.Configuration{TLSClientCRLFile: file})
+	crl = nil
+	t.Cleanup(func() {
+		configuration.Set(configuration.Configuration{})
+		crl = nil
+	})
+	return file
+}
+
+func TestVerifyRevocation(t *testing.T) {
+	ca := newAuthority(t, "ca")
+	other := newAuthority(t, "other")
+	valid := ca.issue(t, 10)
+	revoked := ca.issue(t, 11)
+	foreign := other.issue(t, 11)
+	tomorrow := time.Now().Add(24 * time.Hour)
+
+	tests := []struct {
+		name  string
+		crl   []byte
+		chain []*x509.Certificate
+		err   string
+	}{
+		{name: "valid certificate", crl: ca.revocationList(t, tomorrow, 11), chain: []*x509.Certificate{valid, ca.cert}},
+		{name: "revoked certificate", crl: ca.revocationList(t, tomorrow, 11), chain: []*x509.Certificate{revoked, ca.cert}, err: "is revoked"},
+		{
+			name:  "revoked certificate with pem list",
+			crl:   pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: ca.revocationList(t, tomorrow, 11)}),
+			chain: []*x509.Certificate{revoked, ca.cert},
+			err:   "is revoked",
+		},
+		{name: "list of another issuer", crl: ca.revocationList(t, tomorrow, 11), chain: []*x509.Certificate{foreign, other.cert}},
+		{name: "expired list", crl: ca.revocationList(t, time.Now().Add(-time.Minute)), chain: []*x509.Certificate{valid, ca.cert}, err: "expired"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupCRL(t, test.crl)
+			err := verifyRevocation(nil, [][]*x509.Certificate{test.chain})
+			if test.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("error is %v, expected %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestRevocationListReload(t *testing.T) {
+	ca := newAuthority(t, "ca")
+	cert := ca.issue(t, 10)
+	chain := [][]*x509.Certificate{{cert, ca.cert}}
+	tomorrow := time.Now().Add(24 * time.Hour)
+	file := setupCRL(t, ca.revocationList(t, tomorrow))
+
+	if err := verifyRevocation(nil, chain); err != nil {
+		t.Fatal(err)
+	}
+
+	// updated list is read when file changes
+	if err := ioutil.WriteFile(file, ca.revocationList(t, tomorrow, 10), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyRevocation(nil, chain); err == nil {
+		t.Error("certificate revoked by updated list accepted")
+	}
+
+	// malformed list is reported
+	if err := ioutil.WriteFile(file, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, later.Add(time.Minute), later.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyRevocation(nil, chain); err == nil || !strings.Contains(err.Error(), "malformed") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
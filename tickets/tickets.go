@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package tickets
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/NethServer/ns-api-server/configuration"
+)
+
+// single use credential for one path, for clients that can't send headers
+type Ticket struct {
+	Path   string                 `json:"path"`
+	Expire time.Time              `json:"expire"`
+	Claims map[string]interface{} `json:"-"`
+}
+
+// tickets are kept in memory, by hash
+var tickets = map[string]Ticket{}
+var mutex sync.Mutex
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func Create(path string, claims map[string]interface{}) (Ticket, string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// forget expired tickets
+	now := time.Now()
+	for key, ticket := range tickets {
+		if now.After(ticket.Expire) {
+			delete(tickets, key)
+		}
+	}
+
+	// generate secret, stored as hash
+	random := make([]byte, 32)
+	_, _ = rand.Read(random)
+	secret := hex.EncodeToString(random)
+
+	ticket := Ticket{
+		Path:   path,
+		Expire: now.Add(configuration.Get().TicketsTTL),
+		Claims: claims,
+	}
+	tickets[hash(secret)] = ticket
+
+	return ticket, secret
+}
+
+func Redeem(secret string, path string) (Ticket, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// tickets are removed on first use, even if used on wrong path
+	key := hash(secret)
+	ticket, exists := tickets[key]
+	if !exists {
+		return Ticket{}, false
+	}
+	delete(tickets, key)
+
+	if time.Now().After(ticket.Expire) || ticket.Path != path {
+		return Ticket{}, false
+	}
+
+	return ticket, true
+}
@@ -0,0 +1,32 @@
+//go:build embedui
+// +build embedui
+
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package ui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// web ui build, copied in dist before building
+//
+//go:embed dist
+var dist embed.FS
+
+func init() {
+	files, err := fs.Sub(dist, "dist")
+	if err != nil {
+		panic(err)
+	}
+	embedded = http.FS(files)
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright (C) 2023 Nethesis S.r.l.
+ * http://www.nethesis.it - info@nethesis.it
+ *
+ * SPDX-License-Identifier: GPL-2.0-only
+ *
+ * author: Edoardo Spadoni <edoardo.spadoni@nethesis.it>
+ */
+
+package ui
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NethServer/ns-api-server/configuration"
+)
+
+// files embedded in the binary, set when built with embedui tag
+var embedded http.FileSystem
+
+// precompressed siblings, in order of preference
+var encodings = []struct {
+	name      string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// etags are computed once for each file version
+type etagEntry struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+var etags = map[string]etagEntry{}
+var etagMutex sync.Mutex
+
+func Embedded() bool {
+	return embedded != nil
+}
+
+func fileSystem() http.FileSystem {
+	if embedded != nil {
+		return embedded
+	}
+	return http.Dir(configuration.Get().StaticDir)
+}
+
+func Serve() gin.HandlerFunc {
+	immutable := regexp.MustCompile(configuration.Get().StaticImmutablePattern)
+
+	return func(c *gin.Context) {
+		// api and metrics are served by routes
+		requestPath := path.Clean("/" + c.Request.URL.Path)
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead ||
+			requestPath == "/api" || strings.HasPrefix(requestPath, "/api/") || requestPath == "/metrics" {
+			c.Next()
+			return
+		}
+
+		// directories are served by their index
+		files := fileSystem()
+		name := requestPath
+		if info, err := stat(files, name); err == nil && info.IsDir() {
+			name = path.Join(name, "index.html")
+		}
+
+		// deep links of history mode routing are served by the app index, missing assets are not found
+		if _, err := stat(files, name); err != nil {
+			if strings.Contains(path.Base(name), ".") {
+				c.Next()
+				return
+			}
+			name = "/index.html"
+			if _, err := stat(files, name); err != nil {
+				c.Next()
+				return
+			}
+		}
+
+		serveFile(c, files, name, immutable.MatchString(path.Base(name)))
+		c.Abort()
+	}
+}
+
+func serveFile(c *gin.Context, files http.FileSystem, name string, cacheable bool) {
+	header := c.Writer.Header()
+	header.Add("Vary", "Accept-Encoding")
+
+	// hashed assets never change, others must be revalidated
+	if cacheable {
+		header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		header.Set("Cache-Control", "no-cache")
+	}
+
+	// type of original file, also for compressed siblings
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	// use precompressed sibling, if accepted by client
+	served := name
+	accepted := c.GetHeader("Accept-Encoding")
+	for _, encoding := range encodings {
+		if !acceptsEncoding(accepted, encoding.name) {
+			continue
+		}
+		if info, err := stat(files, name+encoding.extension); err == nil && !info.IsDir() {
+			served = name + encoding.extension
+			header.Set("Content-Encoding", encoding.name)
+			break
+		}
+	}
+
+	file, err := files.Open(served)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	// etag of served representation, conditional requests are handled by ServeContent
+	if etag, err := fileETag(served, info, file); err == nil {
+		header.Set("ETag", etag)
+	}
+
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime(), file)
+}
+
+func stat(files http.FileSystem, name string) (os.FileInfo, error) {
+	file, err := files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return file.Stat()
+}
+
+func fileETag(name string, info os.FileInfo, file http.File) (string, error) {
+	etagMutex.Lock()
+	defer etagMutex.Unlock()
+
+	if entry, exists := etags[name]; exists && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.etag, nil
+	}
+
+	// hash content, then rewind for serving
+	sum := sha256.New()
+	if _, err := io.Copy(sum, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	etag := `"` + hex.EncodeToString(sum.Sum(nil))[:32] + `"`
+	etags[name] = etagEntry{modTime: info.ModTime(), size: info.Size(), etag: etag}
+
+	return etag, nil
+}
+
+func acceptsEncoding(header string, encoding string) bool {
+	// like "gzip, deflate, br;q=0.9", refused encodings have q=0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(fields[0]) != encoding {
+			continue
+		}
+		for _, parameter := range fields[1:] {
+			if parameter = strings.TrimSpace(parameter); strings.HasPrefix(parameter, "q=") {
+				quality, err := strconv.ParseFloat(parameter[2:], 64)
+				return err == nil && quality > 0
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -10,10 +10,15 @@
 package utils
 
 import (
+	"net"
+	"regexp"
 	"strconv"
 	"time"
 )
 
+// user names are path components of secrets and tokens
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
 func Contains(a string, values []string) bool {
 	for _, b := range values {
 		if b == a {
@@ -23,6 +28,32 @@ func Contains(a string, values []string) bool {
 	return false
 }
 
+func AllowedIP(networks []string, clientIP string) bool {
+	// no networks means any address
+	if len(networks) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if _, ipNet, err := net.ParseCIDR(network); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+		if net.ParseIP(network).Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ValidUsername(username string) bool {
+	return usernamePattern.MatchString(username) && username != "." && username != ".."
+}
+
 func EpochToHumanDate(epochTime int) string {
 	i, err := strconv.ParseInt(strconv.Itoa(epochTime), 10, 64)
 	if err != nil {